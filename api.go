@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var apiVersion = flag.String("api-version", "v1", "Version of the AcFun upload API to talk to: "+strings.Join(apiVersions(), ", "))
+
+// UploadAPI is every call of the upload flow besides the fragments
+// themselves. Each version of the AcFun API gets its own implementation,
+// so that a changed server can be followed without breaking the old one.
+type UploadAPI interface {
+	// UploadConfig asks for an upload token for size bytes named name.
+	UploadConfig(ctx context.Context, name string, size int64) (*UploadConfigResp, error)
+	// Resume checks the token, and reports the fragments the server has
+	// if it says so.
+	Resume(ctx context.Context, token string) (*UploadResumeResp, error)
+	// Complete tells the server all fragments of token were sent.
+	Complete(ctx context.Context, token string, fragments int64) error
+	// CreateVideo adds the completed upload of task to the video library.
+	CreateVideo(ctx context.Context, task string, filename string) (*CreateVideoResp, error)
+	// UploadFinish closes task.
+	UploadFinish(ctx context.Context, task string) (*UploadFinishResp, error)
+	// Publish creates the post for videoID and returns its ID.
+	Publish(ctx context.Context, task string, videoID int64, meta *VideoMeta) (int64, error)
+}
+
+var apis = map[string]UploadAPI{
+	"v1": apiV1{},
+}
+
+// api is the implementation picked by -api-version.
+var api UploadAPI = apiV1{}
+
+func apiVersions() []string {
+	versions := make([]string, 0, len(apis))
+	for v := range apis {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// selectAPI sets api from -api-version.
+func selectAPI() error {
+	a, ok := apis[*apiVersion]
+	if !ok {
+		return fmt.Errorf(tr("-api-version must be one of %s, got %q"), strings.Join(apiVersions(), ", "), *apiVersion)
+	}
+	api = a
+	return nil
+}
+
+// apiV1 is the member.acfun.cn and kuaishou mediacloud API the uploader
+// was written against.
+type apiV1 struct{}
+
+func (apiV1) UploadConfig(ctx context.Context, name string, size int64) (*UploadConfigResp, error) {
+	return getUploadConfig(ctx, name, size)
+}
+
+func (apiV1) Resume(ctx context.Context, token string) (*UploadResumeResp, error) {
+	return resumeUpload(ctx, token)
+}
+
+func (apiV1) Complete(ctx context.Context, token string, fragments int64) error {
+	completeURL := fmt.Sprintf("%s?fragment_count=%d&upload_token=%s", UploadComplete, fragments, token)
+	return uploadRequest(ctx, "POST", completeURL)
+}
+
+func (apiV1) CreateVideo(ctx context.Context, task string, filename string) (*CreateVideoResp, error) {
+	return createVideo(ctx, task, filename)
+}
+
+func (apiV1) UploadFinish(ctx context.Context, task string) (*UploadFinishResp, error) {
+	return uploadFinish(ctx, task)
+}
+
+func (apiV1) Publish(ctx context.Context, task string, videoID int64, meta *VideoMeta) (int64, error) {
+	return publishVideo(ctx, task, videoID, meta)
+}
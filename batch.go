@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type UploadResult struct {
+	File     string        `json:"file"`
+	Size     int64         `json:"size"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"seconds"`
+	VideoID  int64         `json:"video_id,omitempty"`
+	URL      string        `json:"url,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Retries  int64         `json:"retries"`
+	Hash     string        `json:"hash,omitempty"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	// BatchETA is the estimated time left for the rest of the batch,
+	// computed right after this file finished.
+	BatchETA   time.Duration `json:"-"`
+	ETASeconds float64       `json:"batch_eta_seconds"`
+
+	// Parallel and ParallelSource tell how many workers uploaded the
+	// file and why, see Upload.
+	Parallel       int     `json:"parallel,omitempty"`
+	ParallelSource string  `json:"parallel_source,omitempty"`
+	SerialFallback bool    `json:"serial_fallback,omitempty"`
+	Throughput     float64 `json:"bytes_per_second,omitempty"`
+
+	// UploadToken, TaskID and FragmentCount are set with -no-finish.
+	UploadToken   string `json:"upload_token,omitempty"`
+	TaskID        string `json:"task_id,omitempty"`
+	FragmentCount int64  `json:"fragment_count,omitempty"`
+}
+
+type BatchSummary struct {
+	Files    []*UploadResult `json:"files"`
+	Total    int             `json:"total"`
+	Uploaded int             `json:"uploaded"`
+	Skipped  int             `json:"skipped"`
+	Failed   int             `json:"failed"`
+	Retries  int64           `json:"retries"`
+	// Throughput is the average over the uploaded files.
+	Throughput float64 `json:"bytes_per_second"`
+}
+
+// Batch keeps the results of a run and estimates the time left for the
+// files not uploaded yet from the throughput of the completed ones.
+type Batch struct {
+	Results []*UploadResult
+	Started time.Time
+
+	sizes     map[string]int64
+	remaining int64
+	doneBytes int64
+	doneTime  time.Duration
+	total     int
+}
+
+func newBatch(files []string) *Batch {
+	b := &Batch{
+		Results: make([]*UploadResult, 0, len(files)),
+		Started: time.Now(),
+		sizes:   make(map[string]int64),
+		total:   len(files),
+	}
+	for _, v := range files {
+		if info, err := os.Stat(v); err == nil && info.Mode().IsRegular() {
+			b.sizes[v] = info.Size()
+			b.remaining += info.Size()
+		}
+	}
+	return b
+}
+
+// Expect adds a file found after the batch started, see -watch.
+func (b *Batch) Expect(file string) {
+	b.total++
+	if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() {
+		b.sizes[file] = info.Size()
+		b.remaining += info.Size()
+	}
+}
+
+// ETA returns the estimated time needed for the remaining bytes, or zero
+// when no file has completed yet.
+func (b *Batch) ETA() time.Duration {
+	if b.doneBytes == 0 || b.doneTime <= 0 {
+		return 0
+	}
+	rate := float64(b.doneBytes) / b.doneTime.Seconds()
+	return time.Duration(float64(b.remaining) / rate * float64(time.Second))
+}
+
+// Progress describes the position of the i-th file in the batch.
+func (b *Batch) Progress(i int) string {
+	if eta := b.ETA(); eta > 0 {
+		return fmt.Sprintf("[%d/%d, batch ETA %v]", i+1, b.total, eta.Round(time.Second))
+	}
+	return fmt.Sprintf("[%d/%d]", i+1, b.total)
+}
+
+func (b *Batch) Add(file string, d time.Duration, up *Upload, err error) {
+	size := b.sizes[file]
+	b.remaining -= size
+	if err == nil {
+		b.doneBytes += size
+		b.doneTime += d
+	}
+	r := &UploadResult{
+		File:     file,
+		Size:     size,
+		Duration: d,
+		Seconds:  d.Seconds(),
+		Err:      err,
+		BatchETA: b.ETA(),
+	}
+	r.ETASeconds = r.BatchETA.Seconds()
+	if up != nil {
+		r.Retries = up.Retries
+		r.Parallel, r.ParallelSource, r.SerialFallback = up.Parallel, up.ParallelSource, up.Serial
+		r.UploadToken, r.TaskID, r.FragmentCount = up.Token, up.TaskID, up.Fragments
+	}
+	if err == nil && d > 0 {
+		r.Throughput = float64(size) / d.Seconds()
+	}
+	if up != nil && err == nil {
+		r.VideoID = up.VideoID
+		r.Hash = up.Hash
+		if up.DougaID != 0 {
+			r.URL = dougaURL(up.DougaID)
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	b.Results = append(b.Results, r)
+}
+
+// Skip records a file that was not uploaded because the manifest already
+// has it.
+func (b *Batch) Skip(file string, e *ManifestEntry) {
+	b.remaining -= b.sizes[file]
+	b.Results = append(b.Results, &UploadResult{
+		File:    file,
+		Size:    b.sizes[file],
+		VideoID: e.VideoID,
+		URL:     e.URL,
+		Skipped: true,
+	})
+}
+
+func (b *Batch) Count() (uploaded, skipped, failed int) {
+	for _, r := range b.Results {
+		switch {
+		case r.Err != nil:
+			failed++
+		case r.Skipped:
+			skipped++
+		default:
+			uploaded++
+		}
+	}
+	return
+}
+
+func (b *Batch) PrintSummary() {
+	if len(b.Results) == 0 {
+		return
+	}
+	uploaded, skipped, failed := b.Count()
+	var retries int64
+	for _, r := range b.Results {
+		retries += r.Retries
+	}
+	throughput := 0.0
+	if b.doneTime > 0 {
+		throughput = float64(b.doneBytes) / b.doneTime.Seconds()
+	}
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(&BatchSummary{
+			Files:      b.Results,
+			Total:      len(b.Results),
+			Uploaded:   uploaded,
+			Skipped:    skipped,
+			Failed:     failed,
+			Retries:    retries,
+			Throughput: throughput,
+		}, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Fprintf(msg, tr("Summary: %d file(s), %d uploaded, %d skipped, %d failed\n"), len(b.Results), uploaded, skipped, failed)
+	for _, r := range b.Results {
+		if r.Err != nil {
+			fmt.Fprintf(msg, tr("  failed: %s: %v\n"), r.File, r.Err)
+		}
+	}
+	if b.Transferred() {
+		fmt.Fprintf(msg, tr("Concurrency: %d retries in total, %s on average\n"), retries, formatRate(throughput))
+		for _, r := range b.Results {
+			if r.Parallel == 0 {
+				continue
+			}
+			serial := ""
+			if r.SerialFallback {
+				serial = tr(", fell back to serial")
+			}
+			fmt.Fprintf(msg, tr("  %s: %d worker(s) from %s%s, %d retries, %s\n"),
+				r.File, r.Parallel, r.ParallelSource, serial, r.Retries, formatRate(r.Throughput))
+		}
+	}
+}
+
+// Transferred reports whether any file got as far as uploading fragments.
+func (b *Batch) Transferred() bool {
+	for _, r := range b.Results {
+		if r.Parallel > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func formatRate(bps float64) string {
+	if bps <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f MiB/s", bps/(1<<20))
+}
+
+// ExitCode returns the exit code of the first failed file, or 0.
+func (b *Batch) ExitCode() int {
+	for _, r := range b.Results {
+		if r.Err != nil {
+			return exitCode(r.Err)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// The cassette modes are left out of -h on purpose, they are meant for
+// tests and bug reports: ACFUN_RECORD=file records every HTTP interaction
+// of a run, ACFUN_REPLAY=file answers the requests from such a recording
+// without touching the network.
+const (
+	recordEnv = "ACFUN_RECORD"
+	replayEnv = "ACFUN_REPLAY"
+)
+
+// scrubbedFields are the top level JSON response fields replaced before
+// an interaction is recorded.
+var scrubbedFields = []string{"token"}
+
+const scrubbed = "<redacted>"
+
+// Interaction is one recorded request and its response. URL goes through
+// redactURL, so the upload token never ends up in a cassette.
+type Interaction struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+func interactionKey(req *http.Request) string {
+	return req.Method + " " + redactURL(req.URL)
+}
+
+// recordTransport passes requests on to next and appends every exchange
+// to the cassette file, which is rewritten each time so that an
+// interrupted run still leaves a usable recording.
+type recordTransport struct {
+	next http.RoundTripper
+	path string
+
+	mu           sync.Mutex
+	interactions []*Interaction
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.interactions = append(t.interactions, &Interaction{
+		Method:      req.Method,
+		URL:         redactURL(req.URL),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(scrubBody(body)),
+	})
+	out, err := json.MarshalIndent(t.interactions, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(t.path, out, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording %s returns error: %v", t.path, err)
+	}
+	return resp, nil
+}
+
+// scrubBody replaces the secrets of a JSON object body, other bodies are
+// kept as they are.
+func scrubBody(body []byte) []byte {
+	fields := make(map[string]json.RawMessage)
+	if json.Unmarshal(body, &fields) != nil {
+		return body
+	}
+	changed := false
+	for _, name := range scrubbedFields {
+		if _, ok := fields[name]; ok {
+			fields[name], _ = json.Marshal(scrubbed)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// replayTransport answers requests from a cassette. Interactions of the
+// same method and URL are served in recorded order, the last one is
+// repeated once they run out so that retries still get an answer.
+type replayTransport struct {
+	mu    sync.Mutex
+	queue map[string][]*Interaction
+}
+
+func loadCassette(path string) (*replayTransport, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []*Interaction
+	if err := json.Unmarshal(body, &interactions); err != nil {
+		return nil, fmt.Errorf("failed reading cassette %s: %v", path, err)
+	}
+	t := &replayTransport{queue: make(map[string][]*Interaction)}
+	for _, i := range interactions {
+		key := i.Method + " " + i.URL
+		t.queue[key] = append(t.queue[key], i)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	key := interactionKey(req)
+	t.mu.Lock()
+	queue := t.queue[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("cassette has no response for %s", key)
+	}
+	i := queue[0]
+	if len(queue) > 1 {
+		t.queue[key] = queue[1:]
+	}
+	t.mu.Unlock()
+
+	header := make(http.Header)
+	if i.ContentType != "" {
+		header.Set("Content-Type", i.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(i.Body))),
+		ContentLength: int64(len(i.Body)),
+		Request:       req,
+	}, nil
+}
+
+// useCassette installs the record or replay transport requested through
+// the environment, if any.
+func useCassette() error {
+	record, replay := os.Getenv(recordEnv), os.Getenv(replayEnv)
+	switch {
+	case record != "" && replay != "":
+		return fmt.Errorf("%s and %s can't be used together", recordEnv, replayEnv)
+	case record != "":
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		client.Transport = &recordTransport{next: next, path: record}
+	case replay != "":
+		t, err := loadCassette(replay)
+		if err != nil {
+			return err
+		}
+		client.Transport = t
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An upload recorded against the fake server is replayed offline: the
+// cassette holds no upload token and answers every request of the same
+// upload again, fragments included.
+func TestCassetteRecordReplay(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	size := int64(5000)
+	content := testContent(size)
+
+	testServer(t, &fakeAcFun{partSize: 1001, parallel: 2})
+	client.Transport = &recordTransport{next: client.Transport, path: cassette}
+	recorded, err := uploadReader(context.Background(), "video.mp4", bytes.NewReader(content), size, nil, "", &VideoMeta{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var interactions []*Interaction
+	if err := json.Unmarshal(body, &interactions); err != nil {
+		t.Fatal(err)
+	}
+	fragments := 0
+	for _, i := range interactions {
+		if strings.Contains(i.URL, "upload_token=tok") || strings.Contains(i.Body, `"tok"`) {
+			t.Errorf("upload token recorded in %s %s: %s", i.Method, i.URL, i.Body)
+		}
+		if strings.Contains(i.URL, "fragment_id=") {
+			fragments++
+		}
+	}
+	if fragments != 5 {
+		t.Errorf("%d fragment(s) recorded, want 5", fragments)
+	}
+
+	// no server from here on, every answer comes from the cassette
+	replay, err := loadCassette(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = replay
+	replayed, err := uploadReader(context.Background(), "video.mp4", bytes.NewReader(content), size, nil, "", &VideoMeta{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.VideoID != recorded.VideoID || replayed.Hash != recorded.Hash {
+		t.Errorf("replayed upload got video %d hash %s, want %d %s", replayed.VideoID, replayed.Hash, recorded.VideoID, recorded.Hash)
+	}
+
+	// upload gets the checksum the server answered the fragment with
+	req, err := http.NewRequest("POST", UploadEndpoint+"?upload_token=x&fragment_id=2", bytes.NewReader(content[2000:3000]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(content[2000:3000])
+	checksum, err := upload(req, 2, 1000)
+	if err != nil {
+		t.Fatalf("replaying fragment 2 returns error: %v", err)
+	}
+	if checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("replayed fragment 2 has checksum %s, want %x", checksum, sum)
+	}
+	req, err = http.NewRequest("POST", UploadEndpoint+"?upload_token=x&fragment_id=9", bytes.NewReader(content[:1000]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload(req, 9, 1000); err == nil {
+		t.Error("a fragment the cassette has no response for was answered")
+	}
+}
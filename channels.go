@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ChannelList = "https://member.acfun.cn/common/api/getChannelList"
+
+	channelCacheTTL = 24 * time.Hour
+)
+
+type Channel struct {
+	ID       int        `json:"channelId"`
+	Name     string     `json:"channelName"`
+	Children []*Channel `json:"children"`
+}
+
+type ChannelListResp struct {
+	Result   int        `json:"result"`
+	Channels []*Channel `json:"channelList"`
+	ErrorMsg string     `json:"error_msg"`
+}
+
+// channelCache is the channel tree saved in the state directory.
+type channelCache struct {
+	Fetched  time.Time  `json:"fetched"`
+	Channels []*Channel `json:"channels"`
+}
+
+func channelCacheFile() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "channels.json"), nil
+}
+
+// getChannels returns the channel tree, from the cache unless it is older
+// than channelCacheTTL or refresh is set.
+func getChannels(ctx context.Context, refresh bool) ([]*Channel, error) {
+	file, err := channelCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	if !refresh {
+		cache := new(channelCache)
+		if body, err := ioutil.ReadFile(file); err == nil && json.Unmarshal(body, cache) == nil &&
+			len(cache.Channels) > 0 && time.Since(cache.Fetched) < channelCacheTTL {
+			if *debug {
+				log.Printf("using channel list cached at %v", cache.Fetched)
+			}
+			return cache.Channels, nil
+		}
+	}
+
+	body, err := request(ctx, ChannelList, "")
+	if err != nil {
+		return nil, err
+	}
+	resp := new(ChannelListResp)
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+	if resp.Result != 0 {
+		return nil, fmt.Errorf("getChannelList returns result %d: %s", resp.Result, resp.ErrorMsg)
+	}
+	if len(resp.Channels) == 0 {
+		return nil, fmt.Errorf("getChannelList returns no channels")
+	}
+	body, err = json.Marshal(&channelCache{Fetched: time.Now(), Channels: resp.Channels})
+	if err == nil {
+		err = ioutil.WriteFile(file, body, 0600)
+	}
+	if err != nil {
+		log.Printf("caching channel list returns error: %v", err)
+	}
+	return resp.Channels, nil
+}
+
+func findChannel(channels []*Channel, id int) *Channel {
+	for _, c := range channels {
+		if c.ID == id {
+			return c
+		}
+		if found := findChannel(c.Children, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func printChannels(channels []*Channel, indent string) {
+	for _, c := range channels {
+		fmt.Fprintf(msg, "%s%d\t%s\n", indent, c.ID, c.Name)
+		printChannels(c.Children, indent+"  ")
+	}
+}
+
+// checkChannel makes sure id is a channel videos can be published to. A
+// channel list that can't be fetched only produces a warning, the server
+// still rejects wrong channels when publishing.
+func checkChannel(ctx context.Context, id int) error {
+	channels, err := getChannels(ctx, *refreshChans)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, tr("warning: can't check -channel %d: %v\n"), id, err)
+		return nil
+	}
+	c := findChannel(channels, id)
+	if c == nil {
+		return fmt.Errorf("unknown channel %d, see -list-channels", id)
+	}
+	if len(c.Children) > 0 {
+		return fmt.Errorf("channel %d (%s) has sub-channels, pick one of them, see -list-channels", id, c.Name)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseClip parses a -clip range like 00:01:00-00:05:00. Each end is
+// [[hh:]mm:]ss with optional fractional seconds.
+func parseClip(s string) (start, end time.Duration, err error) {
+	bounds := strings.Split(s, "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf(tr("-clip must look like 00:01:00-00:05:00, got %q"), s)
+	}
+	if start, err = parseTimestamp(bounds[0]); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseTimestamp(bounds[1]); err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf(tr("-clip ends at %v, before it starts at %v"), end, start)
+	}
+	return start, end, nil
+}
+
+// clipKey is the manifest key of the clip from start to end of a file
+// with the given hash. A clip is other content than the whole file, and
+// than other clips of it, so each gets its own entry.
+func clipKey(hash string, start, end time.Duration) string {
+	return fmt.Sprintf("%s#clip=%v-%v", hash, start, end)
+}
+
+func parseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("bad timestamp %q", s)
+	}
+	var seconds float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// clipVideo copies the start-end segment of file into a temp dir, keeping
+// the file name so the default title stays the same. With -reencode-clip
+// the segment is re-encoded to H.264/AAC in an mp4 instead, so it starts
+// and ends on the exact frames. The caller removes the returned dir.
+func clipVideo(ctx context.Context, file string, start, end time.Duration) (dir, clip string, err error) {
+	if !haveTool("ffprobe") || !haveTool("ffmpeg") {
+		return "", "", fmt.Errorf("ffmpeg and ffprobe are needed for -clip")
+	}
+	d, err := probeDuration(ctx, file)
+	if err != nil {
+		return "", "", err
+	}
+	if end > d {
+		return "", "", fmt.Errorf(tr("-clip ends at %v but %s is only %v long"), end, file, d.Round(time.Second))
+	}
+	dir, err = tempDir("acfun-clip-")
+	if err != nil {
+		return "", "", err
+	}
+	clip = filepath.Join(dir, filepath.Base(file))
+	args := []string{"-v", "error", "-ss", fmt.Sprintf("%.3f", start.Seconds()), "-i", file,
+		"-t", fmt.Sprintf("%.3f", (end - start).Seconds())}
+	if *reencClip {
+		base := filepath.Base(file)
+		clip = filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".mp4")
+		args = append(args, "-c:v", "libx264", "-crf", "18", "-preset", "medium", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart")
+	} else {
+		// stream copy cuts at the nearest key frames, but needs no re-encoding
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	}
+	if _, err = runTool(ctx, "ffmpeg", append(args, "-y", clip)...); err != nil {
+		return dir, "", err
+	}
+	// ffmpeg can succeed without writing a single frame, e.g. for a
+	// segment past the last key frame
+	if info, err := os.Stat(clip); err != nil || info.Size() == 0 {
+		return dir, "", fmt.Errorf("ffmpeg wrote no data for the -clip segment of %s", file)
+	}
+	return dir, clip, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the values read from the config file. Every field is a
+// default: a flag given on the command line always wins over it.
+type Config struct {
+	Token    string   `json:"token"`
+	UID      string   `json:"uid"`
+	Channel  int      `json:"channel,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Desc     string   `json:"desc,omitempty"`
+	Original *bool    `json:"original,omitempty"`
+	Retry    *Retry   `json:"retry,omitempty"`
+}
+
+var configInit = flag.Bool("config-init", false, "Ask for token, uid and a few defaults, write them to the -config file and exit")
+
+// Retry is the retry policy part of the config, delays are Go durations
+// like "2s".
+type Retry struct {
+	Attempts int      `json:"attempts"`
+	Delay    string   `json:"delay"`
+	MaxDelay string   `json:"max_delay"`
+	Jitter   *float64 `json:"jitter"`
+	Budget   int      `json:"budget"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "acfun-uploader", "config.json")
+}
+
+// loadConfig reads the config file at path. An empty path means the
+// default location, which is allowed to be missing.
+func loadConfig(path string) (*Config, error) {
+	optional := path == ""
+	if optional {
+		path = defaultConfigPath()
+	}
+	conf := new(Config)
+	if path == "" {
+		return conf, nil
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return conf, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(body, conf)
+	if err != nil {
+		return nil, err
+	}
+	if r := conf.Retry; r != nil {
+		if r.Attempts < 0 {
+			return nil, fmt.Errorf("%s: retry: attempts can't be negative", path)
+		}
+		for _, d := range []string{r.Delay, r.MaxDelay} {
+			if d == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d)
+			if err != nil {
+				return nil, fmt.Errorf("%s: retry: %v", path, err)
+			}
+			if v < 0 {
+				return nil, fmt.Errorf("%s: retry: delays can't be negative, got %s", path, d)
+			}
+		}
+		if r.Jitter != nil && (*r.Jitter < 0 || *r.Jitter > 1) {
+			return nil, fmt.Errorf("%s: retry: jitter must be between 0 and 1", path)
+		}
+		if r.Budget < 0 {
+			return nil, fmt.Errorf("%s: retry: budget can't be negative", path)
+		}
+	}
+	return conf, nil
+}
+
+// initConfig asks for the values of a new config file on in and writes
+// it to path, or the default location. An existing file is only replaced
+// with -force. It returns the path written.
+func initConfig(path string, in io.Reader) (string, error) {
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return "", fmt.Errorf("no default config location on this system, set -config")
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		return "", fmt.Errorf("%s already exists, pass -force to overwrite it", path)
+	}
+	r := bufio.NewReader(in)
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(msg, prompt)
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			err = nil
+		}
+		return strings.TrimSpace(line), err
+	}
+
+	conf := new(Config)
+	var err error
+	if conf.Token, err = ask("Token (the acPasstoken cookie): "); err != nil {
+		return "", err
+	}
+	if conf.UID, err = ask("User ID (the auth_key cookie): "); err != nil {
+		return "", err
+	}
+	if conf.Token == "" || conf.UID == "" {
+		return "", fmt.Errorf("token and user ID are required")
+	}
+	warnings, err := checkCredentials(conf.Token, conf.UID)
+	if err != nil {
+		return "", err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(msg, tr("warning: %s\n"), w)
+	}
+	channel, err := ask("Default channel ID, see -list-channels (empty for none): ")
+	if err != nil {
+		return "", err
+	}
+	if channel != "" {
+		if conf.Channel, err = strconv.Atoi(channel); err != nil || conf.Channel <= 0 {
+			return "", fmt.Errorf("channel must be a positive number, got %q", channel)
+		}
+	}
+	tags, err := ask("Default tags, comma separated (empty for none): ")
+	if err != nil {
+		return "", err
+	}
+	if tags != "" {
+		if conf.Tags, err = normalizeTags(tags); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	// the token is as good as a password
+	if err := ioutil.WriteFile(path, append(body, '\n'), 0600); err != nil {
+		return "", err
+	}
+	return path, os.Chmod(path, 0600)
+}
+
+// applyConfig fills in every flag that was not set on the command line
+// from the config file.
+func applyConfig(conf *Config) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["token"] && conf.Token != "" {
+		*token = conf.Token
+	}
+	if !set["uid"] && conf.UID != "" {
+		*uid = conf.UID
+	}
+	if !set["channel"] && conf.Channel != 0 {
+		*channel = conf.Channel
+	}
+	if !set["tags"] && len(conf.Tags) > 0 {
+		*tags = strings.Join(conf.Tags, ",")
+	}
+	if !set["desc"] && conf.Desc != "" {
+		*desc = conf.Desc
+	}
+	if !set["original"] && conf.Original != nil {
+		*original = *conf.Original
+	}
+	if r := conf.Retry; r != nil {
+		if !set["retry-attempts"] && r.Attempts > 0 {
+			*retryAttempts = r.Attempts
+		}
+		// validated by loadConfig
+		if !set["retry-delay"] && r.Delay != "" {
+			*retryBase, _ = time.ParseDuration(r.Delay)
+		}
+		if !set["retry-max-delay"] && r.MaxDelay != "" {
+			*retryMax, _ = time.ParseDuration(r.MaxDelay)
+		}
+		if !set["retry-jitter"] && r.Jitter != nil {
+			*retryJitter = *r.Jitter
+		}
+		if !set["retry-budget"] && r.Budget > 0 {
+			*retryBudget = r.Budget
+		}
+	}
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigRetry(t *testing.T) {
+	tests := []struct {
+		retry string
+		err   bool
+	}{
+		{retry: `{}`},
+		{retry: `{"attempts": 0, "delay": "0s", "max_delay": "0s", "jitter": 0, "budget": 0}`},
+		{retry: `{"attempts": 5, "delay": "2s", "max_delay": "1m", "jitter": 1, "budget": 10}`},
+		{retry: `{"attempts": -1}`, err: true},
+		{retry: `{"delay": "-1s"}`, err: true},
+		{retry: `{"max_delay": "-30s"}`, err: true},
+		{retry: `{"delay": "soon"}`, err: true},
+		{retry: `{"jitter": -0.1}`, err: true},
+		{retry: `{"jitter": 1.5}`, err: true},
+		{retry: `{"budget": -1}`, err: true},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, "config.json")
+		if err := ioutil.WriteFile(path, []byte(`{"retry": `+tt.retry+`}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(path)
+		if (err != nil) != tt.err {
+			t.Errorf("%d: retry %s: got error %v, want error %v", i, tt.retry, err, tt.err)
+		}
+	}
+}
+
+func TestApplyConfigRetry(t *testing.T) {
+	zero, half := 0.0, 0.5
+	tests := []struct {
+		name     string
+		retry    Retry
+		attempts int
+		delay    time.Duration
+		maxDelay time.Duration
+		jitter   float64
+		budget   int
+	}{
+		{
+			name:     "zero values keep the defaults",
+			retry:    Retry{},
+			maxDelay: retryMaxDelay, jitter: retryJitterDefault,
+		},
+		{
+			name:     "zero durations and jitter are set",
+			retry:    Retry{Delay: "0s", MaxDelay: "0s", Jitter: &zero},
+			maxDelay: 0, jitter: 0,
+		},
+		{
+			name:     "all set",
+			retry:    Retry{Attempts: 5, Delay: "2s", MaxDelay: "1m", Jitter: &half, Budget: 10},
+			attempts: 5, delay: 2 * time.Second, maxDelay: time.Minute, jitter: 0.5, budget: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, "retry-attempts", "0")
+			setFlag(t, "retry-delay", "0s")
+			setFlag(t, "retry-max-delay", retryMaxDelay.String())
+			setFlag(t, "retry-jitter", "0.2")
+			setFlag(t, "retry-budget", "0")
+			retry := tt.retry
+			applyConfig(&Config{Retry: &retry})
+			if *retryAttempts != tt.attempts || *retryBase != tt.delay || *retryMax != tt.maxDelay ||
+				*retryJitter != tt.jitter || *retryBudget != tt.budget {
+				t.Errorf("got attempts %d, delay %v, max delay %v, jitter %v, budget %d, "+
+					"want %d, %v, %v, %v, %d", *retryAttempts, *retryBase, *retryMax, *retryJitter, *retryBudget,
+					tt.attempts, tt.delay, tt.maxDelay, tt.jitter, tt.budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tokenCookie = "acPasstoken"
+	uidCookie   = "auth_key"
+)
+
+// loadCookieFile reads acPasstoken and auth_key of acfun.cn from a
+// Netscape cookies.txt export, the format written by most browser cookie
+// export extensions and by curl.
+func loadCookieFile(path string) (token, uid string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	found := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		// HttpOnly cookies are exported as comments with this prefix
+		text = strings.TrimPrefix(text, "#HttpOnly_")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return "", "", fmt.Errorf("%s:%d: expected 7 tab separated fields, got %d", path, line, len(fields))
+		}
+		domain, name, value := fields[0], fields[5], fields[6]
+		if name != tokenCookie && name != uidCookie || !isAcfunDomain(domain) {
+			continue
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return "", "", fmt.Errorf("%s:%d: bad expiry %q", path, line, fields[4])
+		}
+		// 0 marks a session cookie
+		if expires != 0 && time.Unix(expires, 0).Before(time.Now()) {
+			return "", "", fmt.Errorf("%s expired on %s, log in again and export a fresh cookie file",
+				name, time.Unix(expires, 0).Format("2006-01-02"))
+		}
+		found[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	for _, name := range []string{tokenCookie, uidCookie} {
+		if found[name] == "" {
+			return "", "", fmt.Errorf("%s has no %s cookie for acfun.cn, export the cookies while logged in", path, name)
+		}
+	}
+	return found[tokenCookie], found[uidCookie], nil
+}
+
+func isAcfunDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	return domain == "acfun.cn" || strings.HasSuffix(domain, ".acfun.cn")
+}
+
+// parseCookieHeader picks acPasstoken and auth_key out of a Cookie header
+// copied from the browser devtools.
+func parseCookieHeader(header string) (token, uid string, err error) {
+	for _, part := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case tokenCookie:
+			token = kv[1]
+		case uidCookie:
+			uid = kv[1]
+		}
+	}
+	if token == "" || uid == "" {
+		return "", "", fmt.Errorf(tr("-cookie must contain both %s and %s"), tokenCookie, uidCookie)
+	}
+	return token, uid, nil
+}
+
+// minTokenLength is well below the length of real acPasstoken values,
+// anything shorter was most likely cut off while copying.
+const minTokenLength = 32
+
+// checkCredentials catches the usual copy-paste mistakes before any
+// request is made. Values that can't be right are errors, odd looking
+// ones only warnings.
+func checkCredentials(token, uid string) (warnings []string, err error) {
+	for _, v := range []string{token, uid} {
+		if strings.Contains(v, tokenCookie+"=") || strings.Contains(v, uidCookie+"=") || strings.Contains(v, ";") {
+			return nil, fmt.Errorf(tr("%q looks like a full cookie string, pass it with -cookie instead of -token/-uid"), v)
+		}
+		if strings.ContainsAny(v, " \t\r\n") {
+			return nil, fmt.Errorf(tr("%q contains whitespace, check that it was copied completely"), v)
+		}
+	}
+	if len(token) < minTokenLength {
+		warnings = append(warnings, fmt.Sprintf(tr("-token is only %d characters long, acPasstoken values are much longer"), len(token)))
+	}
+	if strings.Trim(uid, "0123456789") != "" {
+		warnings = append(warnings, tr("-uid is not a number, auth_key is normally your numeric user ID"))
+	}
+	return warnings, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	CoverConfig = "https://member.acfun.cn/common/api/getKSCloudToken"
+	CoverURL    = "https://member.acfun.cn/common/api/getUrlAfterUpload"
+
+	coverRetries = 5
+	autoCoverAt  = 0.1
+)
+
+type CoverURLResp struct {
+	Result   int    `json:"result"`
+	URL      string `json:"url"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+// uploadCover uploads the cover image and returns the URL to publish it
+// with. The image goes through the same fragment endpoint as the videos,
+// as a single fragment.
+func uploadCover(ctx context.Context, path string) (string, error) {
+	if _, err := getFileInfo(path); err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(path)
+	checkCoverSize(name, content)
+	if *coverFit {
+		name, content, err = fitCover(name, content)
+		if err != nil {
+			return "", err
+		}
+	}
+	var link string
+	err = retry(ctx, "cover upload", retryPolicy(coverRetries, retryDelay), func() error {
+		var err error
+		link, err = uploadImage(ctx, name, content)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if *debug {
+		log.Printf("cover uploaded: %s", link)
+	}
+	return link, nil
+}
+
+func uploadImage(ctx context.Context, name string, content []byte) (string, error) {
+	data := url.Values{
+		"fileName": []string{name},
+		"size":     []string{strconv.Itoa(len(content))},
+		"template": []string{"1"},
+	}
+	body, err := request(ctx, CoverConfig, data.Encode())
+	if err != nil {
+		return "", err
+	}
+	config, err := parseUploadConfig(body)
+	if err != nil {
+		return "", err
+	}
+
+	resumeURL := fmt.Sprintf("%s?upload_token=%s", UploadResume, config.Token)
+	err = uploadRequest(ctx, "GET", resumeURL)
+	if err != nil {
+		return "", err
+	}
+	postURL := fmt.Sprintf("%s?upload_token=%s&fragment_id=0", UploadEndpoint, config.Token)
+	req, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	if err := setFragmentHeaders(req, "application/octet-stream", 0, len(content), int64(len(content))); err != nil {
+		return "", err
+	}
+	_, err = upload(req, 0, len(content))
+	if err != nil {
+		return "", err
+	}
+	completeURL := fmt.Sprintf("%s?fragment_count=1&upload_token=%s", UploadComplete, config.Token)
+	err = uploadRequest(ctx, "POST", completeURL)
+	if err != nil {
+		return "", err
+	}
+
+	data = url.Values{
+		"bizFrom": []string{"web"},
+		"token":   []string{config.Token},
+	}
+	body, err = request(ctx, CoverURL, data.Encode())
+	if err != nil {
+		return "", err
+	}
+	cover := new(CoverURLResp)
+	err = json.Unmarshal(body, cover)
+	if err != nil {
+		return "", err
+	}
+	if cover.Result != 0 || cover.URL == "" {
+		return "", fmt.Errorf("getUrlAfterUpload returns result %d: %s", cover.Result, cover.ErrorMsg)
+	}
+	return cover.URL, nil
+}
+
+// autoCover grabs the frame at autoCoverAt of the video's duration and
+// uploads it as the cover.
+func autoCover(ctx context.Context, file string) (string, error) {
+	if !haveTool("ffprobe") || !haveTool("ffmpeg") {
+		return "", fmt.Errorf("ffmpeg and ffprobe are needed for -auto-cover")
+	}
+	d, err := probeDuration(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	dir, err := tempDir("acfun-cover-")
+	if err != nil {
+		return "", err
+	}
+	defer removeTemp(dir)
+	frame := filepath.Join(dir, "cover.jpg")
+	at := fmt.Sprintf("%.3f", d.Seconds()*autoCoverAt)
+	_, err = runTool(ctx, "ffmpeg", "-v", "error", "-ss", at, "-i", file, "-frames:v", "1", "-q:v", "2", "-y", frame)
+	if err != nil {
+		return "", err
+	}
+	return uploadCover(ctx, frame)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Covers are shown at 16:9. coverWidth x coverHeight is what -cover-fit
+// scales larger images down to, images below the minimum look blurry.
+const (
+	coverWidth     = 1280
+	coverHeight    = 720
+	coverMinWidth  = 640
+	coverMinHeight = 360
+)
+
+// checkCoverSize warns about a cover too small to look sharp. Images that
+// can't be decoded are left for the server to judge.
+func checkCoverSize(name string, content []byte) {
+	c, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+	if c.Width < coverMinWidth || c.Height < coverMinHeight {
+		fmt.Fprintf(msg, tr("warning: cover %s is %dx%d, smaller than the recommended minimum of %dx%d\n"),
+			name, c.Width, c.Height, coverMinWidth, coverMinHeight)
+	}
+}
+
+// fitCover crops the middle 16:9 part of the image and scales it down to
+// coverWidth x coverHeight if larger, returning it as a JPEG. Images that
+// already fit are returned unchanged.
+func fitCover(name string, content []byte) (string, []byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", nil, fmt.Errorf(tr("-cover-fit can't decode %s: %v"), name, err)
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	crop := b
+	switch {
+	case w*9 > h*16+h/2:
+		cw := h * 16 / 9
+		crop.Min.X += (w - cw) / 2
+		crop.Max.X = crop.Min.X + cw
+	case h*16 > w*9+w/2:
+		ch := w * 9 / 16
+		crop.Min.Y += (h - ch) / 2
+		crop.Max.Y = crop.Min.Y + ch
+	}
+	if crop == b && w <= coverWidth {
+		return name, content, nil
+	}
+	dw, dh := crop.Dx(), crop.Dy()
+	if dw > coverWidth {
+		dw, dh = coverWidth, coverHeight
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, crop, dw, dh), &jpeg.Options{Quality: 90}); err != nil {
+		return "", nil, err
+	}
+	fitted := strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+	if *debug {
+		log.Printf("cover %s: cropped %dx%d to %v, scaled to %dx%d", name, w, h, crop, dw, dh)
+	}
+	return fitted, buf.Bytes(), nil
+}
+
+// scaleDown resamples the r part of src to w x h, w <= r.Dx(), averaging
+// the source pixels that fall into every target pixel.
+func scaleDown(src image.Image, r image.Rectangle, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := r.Min.Y + y*r.Dy()/h
+		y1 := r.Min.Y + (y+1)*r.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0 := r.Min.X + x*r.Dx()/w
+			x1 := r.Min.X + (x+1)*r.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var sr, sg, sb, sa, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					sr, sg, sb, sa = sr+cr>>8, sg+cg>>8, sb+cb>>8, sa+ca>>8
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(sr/n), uint8(sg/n), uint8(sb/n), uint8(sa/n)
+		}
+	}
+	return dst
+}
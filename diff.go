@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var diffDir = flag.String("diff", "", "Compare the files in this directory with -manifest-output and exit, "+
+	"listing files not uploaded yet and uploaded videos without a local file")
+
+// DiffReport is the result of -diff. The manifest is the only record of
+// uploaded videos, ones uploaded without it are not known.
+type DiffReport struct {
+	Dir         string           `json:"dir"`
+	Uploaded    int              `json:"uploaded"`
+	NotUploaded []string         `json:"not_uploaded"`
+	NoLocalFile []*ManifestEntry `json:"no_local_file"`
+}
+
+// diffManifest matches the files in dir with the manifest entries by
+// hash. Only a file that can't be read, or an entry hashed with another
+// -checksum-algo, is matched by its path relative to dir instead.
+func diffManifest(m *Manifest, dir string) (*DiffReport, error) {
+	files, err := expandFiles([]string{dir})
+	if err != nil {
+		return nil, err
+	}
+	r := &DiffReport{Dir: dir, NotUploaded: []string{}, NoLocalFile: []*ManifestEntry{}}
+	matched := make(map[*ManifestEntry]bool)
+	byPath := make(map[string][]*ManifestEntry)
+	for _, e := range m.Entries {
+		if rel, ok := relPath(dir, e.Path); ok {
+			byPath[rel] = append(byPath[rel], e)
+		}
+	}
+	for _, v := range files {
+		var found []*ManifestEntry
+		hash, err := hashFile(v)
+		if err == nil {
+			if e := m.Lookup(hash); e != nil {
+				found = append(found, e)
+			}
+		}
+		if rel, ok := relPath(dir, v); ok && len(found) == 0 {
+			for _, e := range byPath[rel] {
+				if err != nil || hashAlgo(e.Hash) != *checksumAlgo {
+					found = append(found, e)
+				}
+			}
+		}
+		if len(found) == 0 {
+			r.NotUploaded = append(r.NotUploaded, v)
+			continue
+		}
+		for _, e := range found {
+			matched[e] = true
+		}
+		r.Uploaded++
+	}
+	for _, e := range m.Entries {
+		if !matched[e] {
+			r.NoLocalFile = append(r.NoLocalFile, e)
+		}
+	}
+	return r, nil
+}
+
+// relPath returns path relative to dir, false when it is not inside dir.
+func relPath(dir, path string) (string, bool) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (r *DiffReport) Print() {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r)
+		return
+	}
+	fmt.Fprintf(msg, tr("%s: %d file(s) uploaded, %d not uploaded, %d uploaded video(s) without a local file\n"),
+		r.Dir, r.Uploaded, len(r.NotUploaded), len(r.NoLocalFile))
+	for _, v := range r.NotUploaded {
+		fmt.Fprintf(msg, tr("  not uploaded: %s\n"), v)
+	}
+	for _, e := range r.NoLocalFile {
+		link := e.URL
+		if link == "" {
+			link = fmt.Sprintf("video %d", e.VideoID)
+		}
+		fmt.Fprintf(msg, tr("  no local file: %s (%s, was %s)\n"), e.Title, link, e.Path)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		v := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(v), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(v, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	hash := func(v string) string {
+		sum, err := hashFile(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	uploaded := write("S1/ep01.mp4", "season 1 episode 1")
+	sameName := write("S2/ep01.mp4", "season 2 episode 1")
+	edited := write("S1/ep02.mp4", "season 1 episode 2")
+	editedEntry := &ManifestEntry{Path: edited, Hash: hash(edited)}
+	write("S1/ep02.mp4", "season 1 episode 2, recut")
+	otherAlgo := write("S1/ep03.mp4", "season 1 episode 3")
+	gone := &ManifestEntry{Path: filepath.Join(dir, "S1/ep04.mp4"), Hash: "0123"}
+
+	m := &Manifest{Entries: []*ManifestEntry{
+		{Path: uploaded, Hash: hash(uploaded)},
+		editedEntry,
+		{Path: otherAlgo, Hash: "sha256:0123"},
+		gone,
+	}}
+	r, err := diffManifest(m, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Uploaded != 2 {
+		t.Errorf("%d file(s) uploaded, want 2", r.Uploaded)
+	}
+	if want := []string{edited, sameName}; !reflect.DeepEqual(r.NotUploaded, want) {
+		t.Errorf("not uploaded %q, want %q", r.NotUploaded, want)
+	}
+	if want := []*ManifestEntry{editedEntry, gone}; !reflect.DeepEqual(r.NoLocalFile, want) {
+		t.Errorf("%d uploaded video(s) without a local file, want the edited and the missing one", len(r.NoLocalFile))
+	}
+}
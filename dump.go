@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+const bodyPreviewSize = 256
+
+// textTypes are the non-text/* media types printed as they are.
+var textTypes = map[string]bool{
+	"application/json":                  true,
+	"application/xml":                   true,
+	"application/x-www-form-urlencoded": true,
+}
+
+var redactedCookies = map[string]bool{
+	"acPasstoken": true,
+	"auth_key":    true,
+}
+
+// printTransport writes every outgoing request to stderr before handing
+// it to the next RoundTripper.
+type printTransport struct {
+	next http.RoundTripper
+}
+
+func (t *printTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// one write per request, so concurrent fragments don't interleave
+	_, _ = io.WriteString(os.Stderr, formatRequest(req))
+	return t.next.RoundTrip(req)
+}
+
+func formatRequest(req *http.Request) string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "> %s %s\n", req.Method, redactURL(req.URL))
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			if strings.EqualFold(k, "cookie") {
+				v = redactCookie(v)
+			}
+			fmt.Fprintf(b, "> %s: %s\n", k, v)
+		}
+	}
+	fmt.Fprintf(b, "> %s\n\n", bodyPreview(req))
+	return b.String()
+}
+
+func bodyPreview(req *http.Request) string {
+	if req.Body == nil || req.GetBody == nil {
+		return "(no body)"
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return fmt.Sprintf("(body unavailable: %v)", err)
+	}
+	defer body.Close()
+	if !textBody(req) {
+		return fmt.Sprintf("(%d bytes of binary data)", req.ContentLength)
+	}
+	preview, err := ioutil.ReadAll(io.LimitReader(body, bodyPreviewSize))
+	if err != nil {
+		return fmt.Sprintf("(body unavailable: %v)", err)
+	}
+	if req.ContentLength > bodyPreviewSize {
+		return fmt.Sprintf("%s... (%d bytes)", preview, req.ContentLength)
+	}
+	return string(preview)
+}
+
+// textBody reports whether the body of req is worth printing. Fragments
+// are video data whatever their Content-Type says, and anything not
+// declared as text is taken as binary.
+func textBody(req *http.Request) bool {
+	if req.URL.Query().Get("fragment_id") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return textTypes[mediaType]
+}
+
+func redactURL(u *url.URL) string {
+	q := u.Query()
+	if q.Get("upload_token") == "" {
+		return u.String()
+	}
+	q.Set("upload_token", "<redacted>")
+	c := *u
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+func redactCookie(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 && redactedCookies[kv[0]] {
+			parts[i] = " " + kv[0] + "=<redacted>"
+			if i == 0 {
+				parts[i] = parts[i][1:]
+			}
+		}
+	}
+	return strings.Join(parts, ";")
+}
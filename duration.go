@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// AcFun documents no duration limits, these bounds are off unless set and
+// only catch videos that are clearly not meant to be uploaded.
+var (
+	minDuration = flag.Duration("min-duration", 0, "Warn about videos shorter than this (0 turns the check off, needs ffprobe)")
+	maxDuration = flag.Duration("max-duration", 0, "Warn about videos longer than this (0 means no limit, needs ffprobe)")
+	strict      = flag.Bool("strict", false, "Skip videos outside -min-duration/-max-duration instead of only warning")
+)
+
+// checkDuration returns a warning about a video outside -min-duration and
+// -max-duration, or an error refusing it with -strict. The clip is checked
+// when -clip is set. Without ffprobe nothing is checked.
+func checkDuration(ctx context.Context, file string, clipFrom, clipTo time.Duration) (string, error) {
+	if *minDuration <= 0 && *maxDuration <= 0 {
+		return "", nil
+	}
+	d := clipTo - clipFrom
+	if *clip == "" {
+		if !haveTool("ffprobe") {
+			if *debug {
+				log.Printf("ffprobe not found, the duration of %s is not checked", file)
+			}
+			return "", nil
+		}
+		var err error
+		d, err = probeDuration(ctx, file)
+		if err != nil {
+			log.Printf("checking the duration of %s: %v", file, err)
+			return "", nil
+		}
+	}
+	var problem string
+	switch {
+	case *minDuration > 0 && d < *minDuration:
+		problem = fmt.Sprintf("%s is %v long, shorter than -min-duration %v", file, d.Round(time.Millisecond), *minDuration)
+	case *maxDuration > 0 && d > *maxDuration:
+		problem = fmt.Sprintf("%s is %v long, longer than -max-duration %v", file, d.Round(time.Second), *maxDuration)
+	default:
+		return "", nil
+	}
+	if *strict {
+		return "", fmt.Errorf("skipped: %s", problem)
+	}
+	return problem + ", AcFun may reject it", nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors callers can check with errors.Is. Only ErrFragmentFailed is
+// retryable: the same fragment may go through on another attempt. The
+// others need the user to act first, retrying the same request won't help.
+var (
+	// ErrAuthFailed means AcFun rejected the token/uid.
+	ErrAuthFailed = errors.New("authentication failed, check -token and -uid")
+	// ErrQuotaExceeded means the account can't upload more for now.
+	ErrQuotaExceeded = errors.New("upload quota exceeded")
+	// ErrUnsupportedFormat means AcFun does not accept the file.
+	ErrUnsupportedFormat = errors.New("unsupported file format")
+	// ErrNotJSON means an HTML page came back where JSON was expected,
+	// usually a maintenance notice or a WAF block.
+	ErrNotJSON = errors.New("server returned non-JSON response (possible maintenance or blocked request)")
+	// ErrFragmentFailed is matched by every *FragmentError.
+	ErrFragmentFailed = errors.New("fragment upload failed")
+)
+
+// FragmentError reports a failed fragment upload along with its index.
+type FragmentError struct {
+	Part int64
+	Err  error
+}
+
+func (e *FragmentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FragmentError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FragmentError) Is(target error) bool {
+	return target == ErrFragmentFailed
+}
+
+// checkJSON returns ErrNotJSON along with the HTTP status when body is
+// not the JSON the API normally answers with.
+func checkJSON(resp *http.Response, body []byte) error {
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") ||
+		bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return fmt.Errorf("%w: %s returns %s", ErrNotJSON, resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// quotaPhrases are found in the messages of results that refuse an upload
+// because of the account's limits. AcFun documents no result codes, so
+// the server's message is all there is to go by.
+var quotaPhrases = []string{"上限", "超出", "已满", "quota", "limit exceeded", "storage full"}
+
+// formatPhrases are found in the messages of results that refuse the
+// file itself, checkFormat only warns since the server has the last word.
+var formatPhrases = []string{"格式", "不支持", "unsupported", "format"}
+
+// resultError reports a non-zero result of an API call, as ErrQuotaExceeded
+// if the message says a limit of the account was reached and as
+// ErrUnsupportedFormat if it refuses the file's format.
+func resultError(api string, result int, errorMsg string) error {
+	m := strings.ToLower(errorMsg)
+	for _, phrase := range quotaPhrases {
+		if strings.Contains(m, phrase) {
+			return fmt.Errorf("%w: %s returns result %d: %s (delete some videos or wait before uploading more)",
+				ErrQuotaExceeded, api, result, errorMsg)
+		}
+	}
+	for _, phrase := range formatPhrases {
+		if strings.Contains(m, phrase) {
+			return fmt.Errorf("%w: %s returns result %d: %s (see -list-formats)",
+				ErrUnsupportedFormat, api, result, errorMsg)
+		}
+	}
+	return fmt.Errorf("%s returns result %d: %s", api, result, errorMsg)
+}
+
+// retryable reports whether trying err's request again can help.
+func retryable(err error) bool {
+	return !errors.Is(err, ErrAuthFailed) && !errors.Is(err, ErrQuotaExceeded) && !errors.Is(err, ErrUnsupportedFormat)
+}
+
+// Exit codes of the command line tool.
+const (
+	exitFailure     = 1
+	exitUsage       = 2
+	exitAuth        = 3
+	exitQuota       = 4
+	exitUnsupported = 5
+	exitFragment    = 6
+	// exitInterrupted is what shells report for a process ended by Ctrl-C.
+	exitInterrupted = 130
+)
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrAuthFailed):
+		return exitAuth
+	case errors.Is(err, ErrQuotaExceeded):
+		return exitQuota
+	case errors.Is(err, ErrUnsupportedFormat):
+		return exitUnsupported
+	case errors.Is(err, ErrFragmentFailed):
+		return exitFragment
+	}
+	return exitFailure
+}
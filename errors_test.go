@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		err         bool
+	}{
+		{"application/json", `{"result":0}`, false},
+		{"", `{"result":0}`, false},
+		{"text/html; charset=utf-8", `<!DOCTYPE html><title>维护中</title>`, true},
+		{"text/html", `{"result":0}`, true},
+		{"application/json", "\n  <html><body>blocked</body></html>", true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{
+			Status:  "503 Service Unavailable",
+			Header:  http.Header{"Content-Type": []string{tt.contentType}},
+			Request: &http.Request{URL: &url.URL{Scheme: "https", Host: "member.acfun.cn"}},
+		}
+		err := checkJSON(resp, []byte(tt.body))
+		if (err != nil) != tt.err {
+			t.Errorf("%q %q: got error %v, want error %v", tt.contentType, tt.body, err, tt.err)
+		}
+		if err != nil && (!errors.Is(err, ErrNotJSON) || !strings.Contains(err.Error(), resp.Status)) {
+			t.Errorf("%q %q: got %v, want ErrNotJSON with the status", tt.contentType, tt.body, err)
+		}
+	}
+}
+
+func TestRequestHTML(t *testing.T) {
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<html><body><h1>502 Bad Gateway</h1></body></html>")
+	}))
+	_, err := getUploadConfig(context.Background(), "a.mp4", 100)
+	if !errors.Is(err, ErrNotJSON) {
+		t.Fatalf("got %v, want ErrNotJSON", err)
+	}
+	if !strings.Contains(err.Error(), "502") {
+		t.Errorf("%v doesn't have the HTTP status", err)
+	}
+}
+
+func TestResultError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want error
+		code int
+	}{
+		{"视频处理失败", nil, exitFailure},
+		{"文件大小超出限制", ErrQuotaExceeded, exitQuota},
+		{"今日投稿已达上限", ErrQuotaExceeded, exitQuota},
+		{"不支持的文件格式", ErrUnsupportedFormat, exitUnsupported},
+		{"Unsupported video format", ErrUnsupportedFormat, exitUnsupported},
+	}
+	for _, tt := range tests {
+		err := resultError("createVideo", 1, tt.msg)
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.msg, err, tt.want)
+		}
+		if !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: %v doesn't have the server's message", tt.msg, err)
+		}
+		if code := exitCode(err); code != tt.code {
+			t.Errorf("%s: exit code %d, want %d", tt.msg, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// speedUnits maps the suffixes accepted by -link-speed to bytes per
+// second. A lower case b means bits.
+var speedUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"Gbps", 1e9 / 8}, {"Mbps", 1e6 / 8}, {"Kbps", 1e3 / 8}, {"kbps", 1e3 / 8}, {"bps", 1.0 / 8},
+	{"GiB/s", 1 << 30}, {"MiB/s", 1 << 20}, {"KiB/s", 1 << 10},
+	{"GB/s", 1e9}, {"MB/s", 1e6}, {"KB/s", 1e3}, {"kB/s", 1e3}, {"B/s", 1},
+}
+
+// parseSpeed parses values like "10Mbps" or "2MB/s" into bytes per second.
+func parseSpeed(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	for _, u := range speedUnits {
+		if !strings.HasSuffix(s, u.suffix) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), 64)
+		if err != nil || v <= 0 {
+			return 0, fmt.Errorf("invalid link speed %q", s)
+		}
+		return v * u.factor, nil
+	}
+	return 0, fmt.Errorf("invalid link speed %q, use a unit like Mbps or MB/s", s)
+}
+
+// estimate prints the expected upload time of files without uploading.
+// The part size and parallelism come from one upload config request,
+// whose round trip also stands in for the per-fragment latency: every
+// round of parallel fragments is assumed to cost one round trip on top
+// of the raw transfer time at the given link speed.
+func estimate(ctx context.Context, files []string) error {
+	speed, err := parseSpeed(*linkSpeed)
+	if err != nil {
+		return err
+	}
+	var sizes []int64
+	var first string
+	for _, v := range files {
+		info, err := getFileInfo(v)
+		if err != nil {
+			return err
+		}
+		if first == "" {
+			first = v
+		}
+		sizes = append(sizes, info.Size())
+	}
+	if first == "" {
+		return fmt.Errorf("no files to estimate")
+	}
+	info, _ := getFileInfo(first)
+	start := time.Now()
+	config, err := api.UploadConfig(ctx, info.Name(), info.Size())
+	if err != nil {
+		return fmt.Errorf("getUploadConfig returns error: %w", err)
+	}
+	rtt := time.Since(start)
+	// part size and parallelism were checked by getUploadConfig
+	partSize := int64(config.Config.PartSize - 1)
+	parallel := int64(config.Config.Parallel)
+
+	var total time.Duration
+	for i, v := range files {
+		parts := (sizes[i] + partSize - 1) / partSize
+		rounds := (parts + parallel - 1) / parallel
+		d := time.Duration(float64(sizes[i])/speed*float64(time.Second)) + time.Duration(rounds)*rtt
+		total += d
+		fmt.Fprintf(msg, tr("Estimate: %s: %d bytes, %d fragment(s), ~%v\n"), v, sizes[i], parts, d.Round(time.Second))
+	}
+	fmt.Fprintf(msg, tr("Estimate: %d file(s), ~%v in total (part size %d, parallel %d, round trip %v)\n"),
+		len(files), total.Round(time.Second), partSize, parallel, rtt.Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FailedEntry is a file that failed in an earlier run, kept in the state
+// directory for -retry-failed.
+type FailedEntry struct {
+	Path  string    `json:"path"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+func failedFile() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "failed.json"), nil
+}
+
+func loadFailed() ([]*FailedEntry, error) {
+	file, err := failedFile()
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*FailedEntry
+	err = json.Unmarshal(body, &entries)
+	return entries, err
+}
+
+// updateFailed merges the results of this run into the failed list:
+// files that failed are added or updated, files that went through are
+// dropped from it.
+func updateFailed(b *Batch) error {
+	entries, err := loadFailed()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int)
+	for i, e := range entries {
+		index[e.Path] = i
+	}
+	for _, r := range b.Results {
+		abs, err := filepath.Abs(r.File)
+		if err != nil {
+			abs = r.File
+		}
+		i, ok := index[abs]
+		switch {
+		case r.Err != nil && ok:
+			entries[i].Error, entries[i].Time = r.Error, time.Now()
+		case r.Err != nil:
+			index[abs] = len(entries)
+			entries = append(entries, &FailedEntry{Path: abs, Error: r.Error, Time: time.Now()})
+		case ok:
+			entries[i] = nil
+		}
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if e != nil {
+			kept = append(kept, e)
+		}
+	}
+
+	file, err := failedFile()
+	if err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		err = os.Remove(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	body, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, body, 0600)
+}
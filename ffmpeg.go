@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func haveTool(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// runTool runs an ffmpeg/ffprobe command and returns its stdout, the
+// tail of stderr is included in the error when it fails.
+func runTool(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if !haveTool(name) {
+		return nil, fmt.Errorf("%s not found in PATH", name)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		out := strings.TrimSpace(stderr.String())
+		if len(out) > 500 {
+			out = "..." + out[len(out)-500:]
+		}
+		return nil, fmt.Errorf("%s failed: %v: %s", name, err, out)
+	}
+	return stdout.Bytes(), nil
+}
+
+func probeDuration(ctx context.Context, file string) (time.Duration, error) {
+	out, err := runTool(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe returned no duration for %s", file)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// audioVideo renders audio over the still image into an mp4 in dir, named
+// after the audio file so the default title stays the same.
+func audioVideo(ctx context.Context, audio, image, dir string) (string, error) {
+	if !haveTool("ffmpeg") {
+		return "", fmt.Errorf("ffmpeg is needed for -audio")
+	}
+	base := filepath.Base(audio)
+	out := filepath.Join(dir, strings.TrimSuffix(base, filepath.Ext(base))+".mp4")
+	_, err := runTool(ctx, "ffmpeg", "-v", "error", "-loop", "1", "-i", image, "-i", audio,
+		"-c:v", "libx264", "-tune", "stillimage", "-pix_fmt", "yuv420p",
+		// x264 needs even dimensions
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2",
+		"-c:a", "aac", "-b:a", "192k", "-shortest", "-y", out)
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var listFormats = flag.Bool("list-formats", false, "Print the video formats and limits known to the tool and exit")
+
+// The AcFun API reports nothing about the formats it accepts. What the
+// tool knows is kept here and in videoTypes, the containers it treats as
+// video, and -list-formats prints exactly this.
+const (
+	codecNote = "not reported by AcFun, every upload is transcoded by the server; " +
+		"-clip with -reencode-clip and -audio produce H.264/AAC in mp4"
+	resolutionNote = "not reported by AcFun"
+	sizeNote       = "none known, set your own with -max-size"
+	durationNote   = "none documented, videos outside -min-duration/-max-duration are warned about"
+)
+
+// knownContainer reports whether file has the extension of a container
+// in videoTypes.
+func knownContainer(file string) bool {
+	_, ok := videoTypes[strings.ToLower(filepath.Ext(file))]
+	return ok
+}
+
+// checkFormat returns a warning when file is not in a known container.
+// It is never refused for that, the server has the last word.
+func checkFormat(file string) string {
+	if knownContainer(file) {
+		return ""
+	}
+	return fmt.Sprintf("%s is not in a known video container (see -list-formats), AcFun may reject it", file)
+}
+
+func printFormats() {
+	exts := make([]string, 0, len(videoTypes))
+	for ext := range videoTypes {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	fmt.Fprint(msg, tr("Containers:\n"))
+	for _, ext := range exts {
+		fmt.Fprintf(msg, "  %-6s %s\n", ext, videoTypes[ext])
+	}
+	fmt.Fprintf(msg, tr("Codecs:     %s\n"), tr(codecNote))
+	fmt.Fprintf(msg, tr("Resolution: %s\n"), tr(resolutionNote))
+	fmt.Fprintf(msg, tr("Size limit: %s\n"), tr(sizeNote))
+	fmt.Fprintf(msg, tr("Duration:   %s\n"), tr(durationNote))
+}
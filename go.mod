@@ -1,6 +1,6 @@
 module acfun-uploader
 
-go 1.14
+go 1.16
 
 require (
 	github.com/cheggaaa/pb/v3 v3.0.5
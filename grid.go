@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/cheggaaa/pb/v3/termutil"
+)
+
+var progressMode = flag.String("progress", "bar", "Progress display: bar, or grid to show the state of every fragment "+
+	"(falls back to bar on terminals narrower than 40 columns)")
+
+// Fragment states drawn by -progress=grid.
+const (
+	fragPending int32 = iota
+	fragUploading
+	fragRetrying
+	fragDone
+)
+
+var fragGlyphs = [...]byte{'.', '>', '!', '#'}
+
+const (
+	minGridWidth = 40
+	maxGridWidth = 100
+	gridInterval = 250 * time.Millisecond
+)
+
+// useGrid reports whether -progress=grid is asked for and fits the
+// terminal.
+func useGrid() bool {
+	if *progressMode != "grid" {
+		return false
+	}
+	if w, err := termutil.TerminalWidth(); err != nil || w < minGridWidth {
+		log.Printf("stderr is not a terminal at least %d columns wide, showing the progress bar instead of the grid", minGridWidth)
+		return false
+	}
+	return true
+}
+
+// fragmentGrid redraws one character per fragment on stderr: . pending,
+// > uploading, ! waiting to retry, # done. A nil grid ignores updates.
+type fragmentGrid struct {
+	states  []int32
+	bar     *pb.ProgressBar
+	lines   int
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func startGrid(fragments int64, bar *pb.ProgressBar) *fragmentGrid {
+	g := &fragmentGrid{
+		states:  make([]int32, fragments),
+		bar:     bar,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go func() {
+		defer close(g.stopped)
+		tick := time.NewTicker(gridInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				g.draw()
+			case <-g.stop:
+				g.draw()
+				return
+			}
+		}
+	}()
+	return g
+}
+
+func (g *fragmentGrid) Set(part int64, state int32) {
+	if g == nil || part < 0 || part >= int64(len(g.states)) {
+		return
+	}
+	atomic.StoreInt32(&g.states[part], state)
+}
+
+// Stop draws the final state and stops redrawing.
+func (g *fragmentGrid) Stop() {
+	if g == nil {
+		return
+	}
+	close(g.stop)
+	<-g.stopped
+}
+
+func (g *fragmentGrid) draw() {
+	width, err := termutil.TerminalWidth()
+	if err != nil || width-1 > maxGridWidth {
+		width = maxGridWidth + 1
+	}
+	width--
+	var b strings.Builder
+	if g.lines > 0 {
+		// back to the top of the last drawing, and clear it
+		fmt.Fprintf(&b, "\x1b[%dA\x1b[J", g.lines)
+	}
+	var counts [len(fragGlyphs)]int
+	lines := 0
+	for i := range g.states {
+		s := atomic.LoadInt32(&g.states[i])
+		counts[s]++
+		b.WriteByte(fragGlyphs[s])
+		if (i+1)%width == 0 || i == len(g.states)-1 {
+			b.WriteByte('\n')
+			lines++
+		}
+	}
+	fmt.Fprintf(&b, "%d/%d done, %d uploading, %d retrying, %s of %s\n",
+		counts[fragDone], len(g.states), counts[fragUploading], counts[fragRetrying],
+		formatSize(g.bar.Current()), formatSize(g.bar.Total()))
+	g.lines = lines + 1
+	_, _ = os.Stderr.WriteString(b.String())
+}
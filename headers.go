@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Every header the uploader sends is set here. The member.acfun.cn API
+// checks that requests come from its upload page, -origin and -referer
+// follow the site without a rebuild if that page ever moves.
+const (
+	defaultOrigin  = "https://member.acfun.cn"
+	defaultReferer = "https://member.acfun.cn/upload-video"
+	userAgent      = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) " +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.149 Safari/537.36"
+)
+
+// headerList collects the repeatable -header flag.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(v string) error {
+	i := strings.Index(v, ":")
+	if i <= 0 || strings.TrimSpace(v[:i]) == "" {
+		return fmt.Errorf("header must look like \"Key: Value\", got %q", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// extraHeaders are added to every API request after the defaults, a
+// header of the same name replaces the default one. The cookie carrying
+// -token and -uid is only touched by an explicit -header "Cookie: ...".
+var extraHeaders headerList
+
+func init() {
+	flag.Var(&extraHeaders, "header", "Extra \"Key: Value\" header for API requests, overriding the default of the same name (repeatable)")
+}
+
+// setAPIHeaders prepares a form request to the member.acfun.cn API.
+func setAPIHeaders(req *http.Request) {
+	req.Header.Set("authority", "member.acfun.cn")
+	req.Header.Set("host", "member.acfun.cn:443")
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("accept", "application/json, text/plain, */*")
+	req.Header.Set("origin", *origin)
+	req.Header.Set("user-agent", userAgent)
+	req.Header.Set("referer", *referer)
+	req.Header.Set("cookie", auth)
+	for _, h := range extraHeaders {
+		i := strings.Index(h, ":")
+		req.Header.Set(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+}
+
+// videoTypes lists the video containers the tool knows, see formats.go.
+// mime.TypeByExtension may not know them, depending on the system's
+// mime.types.
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".flv":  "video/x-flv",
+	".mov":  "video/quicktime",
+	".avi":  "video/x-msvideo",
+	".wmv":  "video/x-ms-wmv",
+	".ts":   "video/mp2t",
+}
+
+// fragmentContentType returns the Content-Type -content-type asks for
+// when uploading file. The web uploader sends application/octet-stream,
+// which is all the server has been seen to accept so far.
+func fragmentContentType(file string) string {
+	switch *contentType {
+	case "":
+		return "application/octet-stream"
+	case "auto":
+		ext := strings.ToLower(filepath.Ext(file))
+		if t, ok := videoTypes[ext]; ok {
+			return t
+		}
+		if t := mime.TypeByExtension(ext); t != "" {
+			return t
+		}
+		return "application/octet-stream"
+	}
+	return *contentType
+}
+
+// checkContentType validates -content-type.
+func checkContentType() error {
+	if *contentType == "" || *contentType == "auto" {
+		return nil
+	}
+	if _, _, err := mime.ParseMediaType(*contentType); err != nil {
+		return fmt.Errorf(tr("-content-type %q is not a MIME type: %v"), *contentType, err)
+	}
+	return nil
+}
+
+// setFragmentHeaders prepares the upload of bytes start to start+length-1
+// of a total bytes long file, a negative total is sent as unknown (see
+// -tail). Content-Length is set here too, from the same length as
+// Content-Range, and a range outside the file is refused: the server
+// would store whatever the two headers say.
+func setFragmentHeaders(req *http.Request, contentType string, start int64, length int, total int64) error {
+	if length <= 0 || start < 0 || total >= 0 && start+int64(length) > total {
+		return fmt.Errorf("fragment of %d bytes at offset %d doesn't fit a %d byte file", length, start, total)
+	}
+	if req.ContentLength > 0 && req.ContentLength != int64(length) {
+		return fmt.Errorf("fragment body is %d bytes, its range is %d", req.ContentLength, length)
+	}
+	req.ContentLength = int64(length)
+	req.Header.Set("Content-Type", contentType)
+	size := "*"
+	if total >= 0 {
+		size = strconv.FormatInt(total, 10)
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, start+int64(length)-1, size))
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestSetFragmentHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  int64
+		length int
+		total  int64
+		body   int64 // Content-Length of the request before, 0 if unset
+		want   string
+		err    bool
+	}{
+		{name: "first fragment", start: 0, length: 100, total: 250, want: "bytes 0-99/250"},
+		{name: "middle fragment", start: 100, length: 100, total: 250, want: "bytes 100-199/250"},
+		{name: "short last fragment", start: 200, length: 50, total: 250, want: "bytes 200-249/250"},
+		{name: "whole file", start: 0, length: 1, total: 1, want: "bytes 0-0/1"},
+		{name: "unknown total", start: 100, length: 100, total: -1, want: "bytes 100-199/*"},
+		{name: "matching body", start: 0, length: 100, total: 100, body: 100, want: "bytes 0-99/100"},
+		{name: "past the end", start: 200, length: 100, total: 250, err: true},
+		{name: "starts at the end", start: 250, length: 1, total: 250, err: true},
+		{name: "negative start", start: -1, length: 100, total: 250, err: true},
+		{name: "empty", start: 0, length: 0, total: 250, err: true},
+		{name: "body of another length", start: 0, length: 100, total: 250, body: 99, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", UploadEndpoint, bytes.NewReader(make([]byte, tt.length)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.ContentLength = tt.body
+			err = setFragmentHeaders(req, "video/mp4", tt.start, tt.length, tt.total)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got Content-Range %q, want an error", req.Header.Get("Content-Range"))
+				}
+				if req.Header.Get("Content-Range") != "" {
+					t.Errorf("Content-Range %q set on a refused fragment", req.Header.Get("Content-Range"))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Content-Range"); got != tt.want {
+				t.Errorf("Content-Range = %q, want %q", got, tt.want)
+			}
+			if req.ContentLength != int64(tt.length) {
+				t.Errorf("Content-Length = %d, want %d", req.ContentLength, tt.length)
+			}
+			if got := req.Header.Get("Content-Type"); got != "video/mp4" {
+				t.Errorf("Content-Type = %q, want video/mp4", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+var langOpt = flag.String("lang", "", "Language of the tool's own messages: en or zh (default from LC_ALL/LC_MESSAGES/LANG)")
+
+// zhMessages translates the messages the tool prints itself, keyed by
+// their English format string, and the flag usages, keyed by their text.
+// Messages from the server are printed as they came, anything missing
+// here stays English.
+var zhMessages = map[string]string{
+	"Local: %s %s\n": "本地文件: %s %s\n",
+	"Summary: %d file(s), %d uploaded, %d skipped, %d failed\n": "汇总: 共 %d 个文件，成功 %d 个，跳过 %d 个，失败 %d 个\n",
+	"  failed: %s: %v\n": "  失败: %s: %v\n",
+	"Concurrency: %d retries in total, %s on average\n":         "并发: 共重试 %d 次，平均速度 %s\n",
+	"  %s: %d worker(s) from %s%s, %d retries, %s\n":            "  %s: %d 个并发（来源 %s%s），重试 %d 次，%s\n",
+	", fell back to serial":                                     "，已回退为串行上传",
+	"Skipped: already uploaded as video %d (%s)\n":              "跳过: 已作为视频 %d 上传过（%s）\n",
+	"Published: %s\n":                                           "已投稿: %s\n",
+	"Resuming: %d of %d fragments already uploaded\n":           "继续上传: %d/%d 个分片已完成\n",
+	"Saved upload of %s can't be resumed (%v), starting over\n": "%s 保存的上传进度无法继续（%v），重新开始上传\n",
+	"Retrying: %s (failed at %s: %s)\n":                         "重试: %s（%s 失败: %s）\n",
+	"No failed files to retry\n":                                "没有需要重试的失败文件\n",
+	"  not uploaded: %s\n":                                      "  未上传: %s\n",
+	"  no local file: %s (%s, was %s)\n":                        "  无本地文件: %s（%s，原文件 %s）\n",
+	"No resumable uploads\n":                                    "没有可以继续的上传\n",
+	"Config written to %s\n":                                    "配置已写入 %s\n",
+	"Watching %s for new files, %d already there are skipped\n": "正在监视 %s 中的新文件，已有的 %d 个文件不会上传\n",
+	"Rendering %s with %s...\n":                                 "正在用 %[2]s 为 %[1]s 生成视频...\n",
+	"warning: %s\n":                                             "警告: %s\n",
+	"warning: publishing %s without a cover: %v\n":              "警告: %s 将不带封面投稿: %v\n",
+	"warning: -keys is ignored, stdin is not a terminal\n":      "警告: 标准输入不是终端，-keys 无效\n",
+	"warning: ffmpeg not found, -strip-metadata is ignored\n":   "警告: PATH 中没有 ffmpeg，-strip-metadata 无效，文件将原样上传\n",
+	"token or uid is missing\n":                                 "缺少 token 或 uid\n",
+	"Usage of %s:\n":                                            "用法: %s [参数] 文件...\n",
+	"%s: %d file(s) uploaded, %d not uploaded, %d uploaded video(s) without a local file\n": "%s: 已上传 %d 个文件，未上传 %d 个，%d 个已上传视频没有对应的本地文件\n",
+	"warning: cover %s is %dx%d, smaller than the recommended minimum of %dx%d\n":           "警告: 封面 %s 尺寸为 %dx%d，小于建议的最小尺寸 %dx%d\n",
+	"Dry upload: %s sent in %v, %s, the upload is left unfinished\n":                        "试上传: %s 用时 %v，%s，上传未完成，不会生成视频\n",
+	"Transferred: %s, finish it with -upload-token %s -task-id %s -finish-fragments %d\n":   "已传完: %s，之后可以用 -upload-token %s -task-id %s -finish-fragments %d 完成上传\n",
+	"warning: -tail is experimental, the server may reject a growing file\n":                "警告: -tail 是实验性功能，服务端可能拒绝在最终大小确定前上传的分片\n",
+	"Aborted: %s failed and -fail-fast is set, %d file(s) not attempted\n":                  "已中止: %s 上传失败且设置了 -fail-fast，剩余 %d 个文件未上传\n",
+
+	// printed by -list-formats and -list-resumable
+	"resumable":            "可以继续",
+	"source file is gone":  "源文件已不存在",
+	"source file changed":  "源文件已修改",
+	"token likely expired": "上传凭证可能已过期",
+	"not reported by AcFun, every upload is transcoded by the server; -clip with -reencode-clip and -audio produce H.264/AAC in mp4": "AcFun 未公开，所有上传都会由服务端转码；-clip 加 -reencode-clip 以及 -audio 生成的是 mp4 格式的 H.264/AAC",
+	"not reported by AcFun":                   "AcFun 未公开",
+	"none known, set your own with -max-size": "未知，可以用 -max-size 自行设置",
+	"none documented, videos outside -min-duration/-max-duration are warned about": "没有公开的限制，超出 -min-duration/-max-duration 的视频会给出警告",
+
+	// the messages of run and of the flag checks
+	"interrupted\n":                                                   "已中断\n",
+	"startProfiles returns error: %v\n":                               "startProfiles 出错: %v\n",
+	"initConfig returns error: %v\n":                                  "initConfig 出错: %v\n",
+	"loadConfig returns error: %v\n":                                  "loadConfig 出错: %v\n",
+	"openLog returns error: %v\n":                                     "openLog 出错: %v\n",
+	"diffManifest returns error: %v\n":                                "diffManifest 出错: %v\n",
+	"listing resume states returns error: %v\n":                       "列出上传进度出错: %v\n",
+	"expandFiles returns error: %v\n":                                 "expandFiles 出错: %v\n",
+	"loadFailed returns error: %v\n":                                  "loadFailed 出错: %v\n",
+	"creating temp dir returns error: %v\n":                           "创建临时目录出错: %v\n",
+	"audioVideo returns error: %v\n":                                  "audioVideo 出错: %v\n",
+	"getChannels returns error: %v\n":                                 "getChannels 出错: %v\n",
+	"uploadCover returns error: %v\n":                                 "uploadCover 出错: %v\n",
+	"saving manifest returns error: %v\n":                             "保存清单出错: %v\n",
+	"watchDir returns error: %v\n":                                    "watchDir 出错: %v\n",
+	"updateFailed returns error: %v\n":                                "updateFailed 出错: %v\n",
+	"writeMetrics returns error: %v\n":                                "writeMetrics 出错: %v\n",
+	"writeHeapProfile returns error: %v\n":                            "writeHeapProfile 出错: %v\n",
+	"WARNING: -insecure is set, TLS certificates are NOT verified.\n": "警告: 设置了 -insecure，不会校验 TLS 证书。\n",
+	"WARNING: your token can be read by anyone between you and AcFun, use it for debugging only.\n": "警告: 你和 AcFun 之间的任何人都能读到你的 token，只在调试时使用。\n",
+	"warning: can't check -channel %d: %v\n":                                                        "警告: 无法检查 -channel %d: %v\n",
+	"Kept temp files in %s\n":                                                                       "临时文件保留在 %s\n",
+	"Estimate: %s: %d bytes, %d fragment(s), ~%v\n":                                                 "预计: %s: %d 字节，%d 个分片，约 %v\n",
+	"Estimate: %d file(s), ~%v in total (part size %d, parallel %d, round trip %v)\n":               "预计: 共 %d 个文件，约 %v（分片大小 %d，并发 %d，往返延迟 %v）\n",
+	"Containers:\n":                 "容器格式:\n",
+	"Codecs:     %s\n":              "编码: %s\n",
+	"Resolution: %s\n":              "分辨率: %s\n",
+	"Size limit: %s\n":              "大小限制: %s\n",
+	"Duration:   %s\n":              "时长: %s\n",
+	"%s\t%5.1f%%\t%v old\t%s\t%s\n": "%s\t%5.1f%%\t%v 前\t%s\t%s\n",
+	"  removed\n":                   "  已删除\n",
+	"Trace summary (averages, connect/tls over new connections only):\n":                                                                           "请求耗时汇总（平均值，connect/tls 只统计新建连接）:\n",
+	"  %s: %d request(s), %d connection(s), dns=%v connect=%v tls=%v ttfb=%v\n":                                                                    "  %s: %d 个请求，%d 个连接，dns=%v connect=%v tls=%v 首字节=%v\n",
+	"-token/-uid, -cookie-file and -cookie can't be used together\n":                                                                               "-token/-uid、-cookie-file 和 -cookie 不能同时使用\n",
+	"-diff compares with the -manifest-output of earlier uploads, set it as well\n":                                                                "-diff 对比的是之前上传记录的 -manifest-output，请同时设置它\n",
+	"-watch takes its files from the watched directory, don't pass any\n":                                                                          "-watch 从监视的目录中获取文件，不要再传入文件\n",
+	"-retry-failed takes its files from the failed list, don't pass any\n":                                                                         "-retry-failed 从失败列表中获取文件，不要再传入文件\n",
+	"-audio uploads a single audio file, don't pass any other files\n":                                                                             "-audio 只上传一个音频文件，不要再传入其他文件\n",
+	"-tail follows a single recording, pass exactly one file\n":                                                                                    "-tail 只跟踪一个录制中的文件，请只传入一个文件\n",
+	"-resume-from belongs to a single upload, pass exactly one file\n":                                                                             "-resume-from 对应单个上传，请只传入一个文件\n",
+	"-upload-token belongs to a single upload, pass exactly one file\n":                                                                            "-upload-token 对应单个上传，请只传入一个文件\n",
+	"-audio needs a -cover image to show in the video":                                                                                             "-audio 需要一张 -cover 图片作为视频画面",
+	"-audio and -auto-cover can't be used together":                                                                                                "-audio 和 -auto-cover 不能同时使用",
+	"-cover is only used when publishing, set -channel as well":                                                                                    "-cover 只在投稿时使用，请同时设置 -channel",
+	"-auto-cover is only used when publishing, set -channel as well":                                                                               "-auto-cover 只在投稿时使用，请同时设置 -channel",
+	"-cover and -auto-cover can't be used together":                                                                                                "-cover 和 -auto-cover 不能同时使用",
+	"-dry-upload only measures the transfer, it can't be used with -resume, -cover, -auto-cover or -upload-token":                                  "-dry-upload 只测量传输速度，不能与 -resume、-cover、-auto-cover 或 -upload-token 同时使用",
+	"-cover-fit needs a -cover or -auto-cover":                                                                                                     "-cover-fit 需要 -cover 或 -auto-cover",
+	"-concurrency-auto and -parallel-safe can't be used together":                                                                                  "-concurrency-auto 和 -parallel-safe 不能同时使用",
+	"-title and -title-template can't be used together":                                                                                            "-title 和 -title-template 不能同时使用",
+	"-upload-token and -task-id must be given together":                                                                                            "-upload-token 和 -task-id 必须同时给出",
+	"-progress must be bar or grid, got %q":                                                                                                        "-progress 只能是 bar 或 grid，而不是 %q",
+	"-min-duration and -max-duration can't be negative, and -max-duration must be above -min-duration":                                             "-min-duration 和 -max-duration 不能为负数，且 -max-duration 必须大于 -min-duration",
+	"-resume can't be combined with -upload-token":                                                                                                 "-resume 不能与 -upload-token 同时使用",
+	"-resume-from continues a saved upload, set -resume as well":                                                                                   "-resume-from 用于继续保存的上传，请同时设置 -resume",
+	"-finish-fragments needs -upload-token and -task-id, and a positive fragment count":                                                            "-finish-fragments 需要 -upload-token 和 -task-id，且分片数必须为正数",
+	"-finish-fragments sends no data, it can't be used with -dry-upload, -no-finish or -clip":                                                      "-finish-fragments 不发送数据，不能与 -dry-upload、-no-finish 或 -clip 同时使用",
+	"-no-finish and -dry-upload can't be used together":                                                                                            "-no-finish 和 -dry-upload 不能同时使用",
+	"-retry-attempts, -retry-delay and -retry-max-delay can't be negative":                                                                         "-retry-attempts、-retry-delay 和 -retry-max-delay 不能为负数",
+	"-retry-jitter must be between 0 and 1":                                                                                                        "-retry-jitter 必须在 0 到 1 之间",
+	"-retry-budget can't be negative":                                                                                                              "-retry-budget 不能为负数",
+	"-lang must be en or zh, got %q":                                                                                                               "-lang 只能是 en 或 zh，而不是 %q",
+	"-checksum-algo must be md5 or sha256, got %q":                                                                                                 "-checksum-algo 只能是 md5 或 sha256，而不是 %q",
+	"-estimate needs -link-speed, e.g. -link-speed 10Mbps":                                                                                         "-estimate 需要 -link-speed，例如 -link-speed 10Mbps",
+	"-original-declare only applies to original works, it can't be used with -original=false":                                                      "-original-declare 只适用于原创作品，不能与 -original=false 同时使用",
+	"-source is the origin of a reprint, set -original=false as well":                                                                              "-source 是转载的来源，请同时设置 -original=false",
+	"-source must be an http(s) URL, got %q":                                                                                                       "-source 必须是 http(s) 网址，而不是 %q",
+	"-reprint-from is the origin of a reprint, set -original=false as well":                                                                        "-reprint-from 是转载的来源，请同时设置 -original=false",
+	"-reprint-from and -source both give the origin of the reprint, use one of them":                                                               "-reprint-from 和 -source 都用于指定转载来源，只能使用其中一个",
+	"-original-declare, -source and -reprint-from are only used when publishing, set -channel as well":                                             "-original-declare、-source 和 -reprint-from 只在投稿时使用，请同时设置 -channel",
+	"-clip uploads a new temp file every run, it can't be resumed":                                                                                 "-clip 每次运行都会上传新的临时文件，无法继续上传",
+	"-reencode-clip changes how -clip cuts, set -clip as well":                                                                                     "-reencode-clip 改变的是 -clip 的剪切方式，请同时设置 -clip",
+	"-strip-metadata uploads a new temp file every run, it can't be resumed":                                                                       "-strip-metadata 每次运行都会上传新的临时文件，无法继续上传",
+	"-draft is not supported: no draft state is known in the AcFun publish API, omit -channel to upload into the video library without publishing": "不支持 -draft: AcFun 投稿接口中没有已知的草稿状态，不设置 -channel 即可只上传到视频库而不投稿",
+	"-replace is not supported: no call for swapping the media of a published video is known in the AcFun API, upload the file as a new video and edit the old submission on the website": "不支持 -replace: AcFun 接口中没有已知的替换已投稿视频的方法，请作为新视频上传，再到网页上编辑原稿件",
+	"-api-version must be one of %s, got %q":                                          "-api-version 只能是 %s 之一，而不是 %q",
+	"-clip must look like 00:01:00-00:05:00, got %q":                                  "-clip 的格式应为 00:01:00-00:05:00，而不是 %q",
+	"-clip ends at %v, before it starts at %v":                                        "-clip 的结束时间 %v 早于开始时间 %v",
+	"-clip ends at %v but %s is only %v long":                                         "-clip 结束于 %v，但 %s 只有 %v 长",
+	"%q looks like a full cookie string, pass it with -cookie instead of -token/-uid": "%q 看起来是完整的 cookie 字符串，请用 -cookie 而不是 -token/-uid 传入",
+	"%q contains whitespace, check that it was copied completely":                     "%q 中有空白字符，请检查是否完整复制",
+	"-token is only %d characters long, acPasstoken values are much longer":           "-token 只有 %d 个字符，acPasstoken 通常长得多",
+	"-uid is not a number, auth_key is normally your numeric user ID":                 "-uid 不是数字，auth_key 通常是数字形式的用户 ID",
+	"-cookie must contain both %s and %s":                                             "-cookie 必须同时包含 %s 和 %s",
+	"-cover-fit can't decode %s: %v":                                                  "-cover-fit 无法解码 %s: %v",
+	"-content-type %q is not a MIME type: %v":                                         "-content-type %q 不是 MIME 类型: %v",
+	"-tail-quiet must be positive":                                                    "-tail-quiet 必须为正数",
+	"-tail can't be used with -resume, -clip, -strip-metadata, -dry-upload, -no-finish, -upload-token, -concurrency-auto or -manifest-output": "-tail 不能与 -resume、-clip、-strip-metadata、-dry-upload、-no-finish、-upload-token、-concurrency-auto 或 -manifest-output 同时使用",
+	"-watch and -retry-failed can't be used together": "-watch 和 -retry-failed 不能同时使用",
+	"-watch and -audio can't be used together":        "-watch 和 -audio 不能同时使用",
+	"-watch and -upload-token can't be used together": "-watch 和 -upload-token 不能同时使用",
+	"-watch and -estimate can't be used together":     "-watch 和 -estimate 不能同时使用",
+	"-watch-settle must be positive":                  "-watch-settle 必须为正数",
+
+	// the flag usages printed by printDefaults
+	"Version of the AcFun upload API to talk to: v1":                                                                                              "要使用的 AcFun 上传接口版本: v1",
+	"Upload this audio file as a video showing the -cover image (needs ffmpeg)":                                                                   "把这个音频文件配上 -cover 图片作为视频上传（需要 ffmpeg）",
+	"Use the frame at 10% of each video as its cover (needs ffmpeg and ffprobe)":                                                                  "使用每个视频 10% 处的画面作为封面（需要 ffmpeg 和 ffprobe）",
+	"Channel ID to publish to, uploads without a channel are only added to the video library":                                                     "投稿的频道 ID，不设置频道时只上传到视频库",
+	"Hash of whole files recorded in the summary and manifest: md5 or sha256":                                                                     "汇总和清单中记录的整个文件的哈希算法: md5 或 sha256",
+	"Delete the resume states that can't be resumed any more and exit":                                                                            "删除已经无法继续的上传进度后退出",
+	"Upload only this segment of each file, e.g. 00:01:00-00:05:00 (needs ffmpeg and ffprobe)":                                                    "只上传每个文件中的这一段，例如 00:01:00-00:05:00（需要 ffmpeg 和 ffprobe）",
+	"Probe the link with the first fragments and pick the fastest parallelism":                                                                    "用最初的几个分片测试网络，选择最快的并发数",
+	"Config file path (default <user config dir>/acfun-uploader/config.json)":                                                                     "配置文件路径（默认为 <用户配置目录>/acfun-uploader/config.json）",
+	"Ask for token, uid and a few defaults, write them to the -config file and exit":                                                              "询问 token、uid 和一些默认值，写入 -config 文件后退出",
+	"Content-Type of video fragments: a MIME type, or auto to guess it from the file extension (default application/octet-stream)":                "视频分片的 Content-Type: MIME 类型，或 auto 表示按扩展名推断（默认 application/octet-stream）",
+	"Full cookie string copied from the browser, sent as is instead of -token and -uid":                                                           "从浏览器复制的完整 cookie 字符串，代替 -token 和 -uid 原样发送",
+	"Read token and uid from a Netscape cookies.txt exported from your browser":                                                                   "从浏览器导出的 Netscape 格式 cookies.txt 中读取 token 和 uid",
+	"Cover image used when publishing":                                                                                                            "投稿时使用的封面图片",
+	"Crop the cover to 16:9 and scale it down to 1280x720 if it is larger":                                                                        "把封面裁剪为 16:9，大于 1280x720 时缩小到 1280x720",
+	"Video description when publishing":                                                                                                           "投稿时的视频简介",
+	"Compare the files in this directory with -manifest-output and exit, listing files not uploaded yet and uploaded videos without a local file": "对比这个目录中的文件和 -manifest-output 后退出，列出还没有上传的文件和没有本地文件的已上传视频",
+	"Save as draft instead of publishing (not supported yet, see README)":                                                                         "保存为草稿而不是投稿（暂不支持，见 README）",
+	"Send the fragments to measure the upload speed, but never finish or publish the upload (leaves an unfinished upload on the server)":          "发送分片以测量上传速度，但不完成上传也不投稿（会在服务端留下未完成的上传）",
+	"Print the expected upload time of the files without uploading, needs -link-speed":                                                            "不上传，只输出文件的预计上传时间，需要 -link-speed",
+	"Stop the batch at the first file that fails, the rest are not attempted":                                                                     "在第一个失败的文件处中止批量上传，剩下的文件不再尝试",
+	"Time limit for each file, the batch moves on when exceeded (0 means no limit)":                                                               "每个文件的时间限制，超时后继续上传下一个文件（0 表示不限制）",
+	"Only finish and publish the upload of -upload-token, whose fragments were all sent by another process, given their count":                    "只完成并投稿 -upload-token 的上传，其分片已全部由其他进程发送，参数为分片总数",
+	"Upload files over -max-size anyway, or overwrite an existing config file with -config-init":                                                  "仍然上传超过 -max-size 的文件，或在 -config-init 时覆盖已有的配置文件",
+	"Extra \"Key: Value\" header for API requests, overriding the default of the same name (repeatable)":                                          "API 请求额外的 \"Key: Value\" 请求头，会覆盖同名的默认值（可重复）",
+	"Skip TLS certificate verification, only for debugging through an intercepting proxy":                                                         "不校验 TLS 证书，只用于通过抓包代理调试",
+	"Print the batch summary as JSON to stdout, other messages go to stderr":                                                                      "把批量上传的汇总以 JSON 输出到 stdout，其他信息输出到 stderr",
+	"Keep the temp files after upload, for debugging":                                                                                             "上传后保留临时文件，用于调试",
+	"Type + or - and Enter during an upload to add or remove a worker (terminal only)":                                                            "上传时输入 + 或 - 并回车来增加或减少并发（仅限终端）",
+	"Language of the tool's own messages: en or zh (default from LC_ALL/LC_MESSAGES/LANG)":                                                        "本工具自身信息的语言: en 或 zh（默认根据 LC_ALL/LC_MESSAGES/LANG）",
+	"Upload speed of your link for -estimate, e.g. 10Mbps or 2MB/s":                                                                               "-estimate 使用的上传带宽，例如 10Mbps 或 2MB/s",
+	"List the channels videos can be published to and exit":                                                                                       "列出可以投稿的频道后退出",
+	"Print the video formats and limits known to the tool and exit":                                                                               "输出本工具已知的视频格式和限制后退出",
+	"List the interrupted uploads -resume can continue and exit":                                                                                  "列出 -resume 可以继续的中断上传后退出",
+	"Write log messages to this file instead of stderr":                                                                                           "把日志写入这个文件而不是 stderr",
+	"Rotate the -log file when it exceeds this many MiB, keeping 5 old segments (0 means no rotation)":                                            "-log 文件超过这么多 MiB 时轮转，保留 5 个旧文件（0 表示不轮转）",
+	"Record uploaded files in this manifest (.csv or JSON), files already in it are skipped":                                                      "在这个清单文件（.csv 或 JSON）中记录已上传的文件，已在其中的文件会跳过",
+	"Warn about videos longer than this (0 means no limit, needs ffprobe)":                                                                        "对长于此时长的视频给出警告（0 表示不限制，需要 ffprobe）",
+	"Refuse files larger than this, e.g. 4GiB or 500MB, unless -force is given":                                                                   "拒绝上传大于此大小的文件，例如 4GiB 或 500MB，除非设置了 -force",
+	"Write run metrics to this file in Prometheus textfile collector format":                                                                      "以 Prometheus textfile collector 格式把运行指标写入这个文件",
+	"Warn about videos shorter than this (0 turns the check off, needs ffprobe)":                                                                  "对短于此时长的视频给出警告（0 表示关闭检查，需要 ffprobe）",
+	"Disable colored output (also set by the NO_COLOR environment variable)":                                                                      "关闭彩色输出（也可以设置环境变量 NO_COLOR）",
+	"Send the fragments but leave the finishing to a later run, printing the -upload-token, -task-id and -finish-fragments it needs":              "只发送分片，把收尾留给之后的运行，并输出收尾所需的 -upload-token、-task-id 和 -finish-fragments",
+	"Origin header sent to the AcFun API":                                                                                                         "发送给 AcFun 接口的 Origin 请求头",
+	"Declare the video as original work when publishing (use -original=false for reprints)":                                                       "投稿时声明为原创（转载请使用 -original=false）",
+	"Add the original work declaration (no reposting without permission) to an original video":                                                    "为原创视频添加原创声明（未经授权禁止转载）",
+	"Upload fragments one at a time, for accounts that reject concurrent fragments":                                                               "一次只上传一个分片，用于拒绝并发分片的账号",
+	"Prefix every title with the name of the file's directory, e.g. \"Season 1 - ep01\"":                                                          "在每个标题前加上文件所在目录的名称，例如 \"Season 1 - ep01\"",
+	"Print every outgoing request (credentials redacted) to stderr":                                                                               "把每个发出的请求（隐去凭证）输出到 stderr",
+	"Progress display: bar, or grid to show the state of every fragment (falls back to bar on terminals narrower than 40 columns)":                "进度显示方式: bar，或 grid 显示每个分片的状态（终端宽度不足 40 列时回退为 bar）",
+	"Upload the files inside directories given as arguments":                                                                                      "上传作为参数传入的目录中的文件",
+	"Re-encode -clip segments (H.264/AAC) so they start and end on the exact frames, slower than the default key frame cut":                       "重新编码 -clip 的片段（H.264/AAC），使其精确地从指定帧开始和结束，比默认的按关键帧剪切慢",
+	"Referer header sent to the AcFun API, change it if the upload page moves":                                                                    "发送给 AcFun 接口的 Referer 请求头，上传页面地址变化时修改它",
+	"Fetch the channel list again instead of using the one cached for 24h":                                                                        "重新获取频道列表，而不是使用缓存 24 小时的列表",
+	"Replace the media of this published video, keeping its ID and stats (not supported yet, see README)":                                         "替换这个已投稿视频的媒体文件，保留其 ID 和数据（暂不支持，见 README）",
+	"AcFun video a reprint comes from, e.g. ac12345, sent as its URL in place of -source":                                                         "转载视频的 AcFun 来源，例如 ac12345，会代替 -source 以网址形式发送",
+	"Save upload progress and continue interrupted uploads of the same file":                                                                      "保存上传进度，并继续同一文件中断的上传",
+	"Continue the upload saved in this resume state file, copied from another machine along with the file (needs -resume)":                        "继续这个上传进度文件中保存的上传，该文件与视频文件一起从另一台机器复制过来（需要 -resume）",
+	"Attempts per request or fragment before giving up (0 means 3 for API calls and the server's retryCount, or no limit, for fragments)":         "每个请求或分片放弃前的尝试次数（0 表示 API 调用尝试 3 次，分片使用服务端的 retryCount 或不限次数）",
+	"Fail a file once its fragments were retried this many times in total (0 means no limit)":                                                     "一个文件的分片总共重试这么多次后判定该文件失败（0 表示不限制）",
+	"Delay before the first retry, doubled after every failure (0 means 1s or the server's retryDurationSeconds)":                                 "第一次重试前的等待时间，之后每次失败翻倍（0 表示 1s 或服务端的 retryDurationSeconds）",
+	"Upload again the files that failed in earlier runs (combine with -resume to keep their progress)":                                            "重新上传之前运行中失败的文件（配合 -resume 可以保留进度）",
+	"Randomize every retry delay by up to this fraction (0 to 1, 0 turns it off)":                                                                 "让每次重试等待时间随机浮动最多这个比例（0 到 1，0 表示关闭）",
+	"Longest delay between two retries":                                                                                                           "两次重试之间的最长等待时间",
+	"Save the raw createVideo, uploadFinish and createDouga responses of every upload in this directory":                                          "把每次上传的 createVideo、uploadFinish 和 createDouga 原始响应保存到这个目录",
+	"Source URL of a reprint, only with -original=false":                                                                                          "转载的来源网址，只能与 -original=false 一起使用",
+	"Retry a fragment when its upload sends no data for this long (0 means only the request timeout applies)":                                     "分片上传在这么长时间内没有发送数据时重试（0 表示只受请求超时限制）",
+	"Skip videos outside -min-duration/-max-duration instead of only warning":                                                                     "跳过超出 -min-duration/-max-duration 的视频，而不只是警告",
+	"Remux each file without its metadata tags (location, device, comments) before uploading, streams are copied as is (needs ffmpeg)":            "上传前去掉每个文件的元数据标签（位置、设备、注释）重新封装，音视频流原样复制（需要 ffmpeg）",
+	"Comma separated tags when publishing (space separated if there is no comma)":                                                                 "投稿时的标签，以逗号分隔（没有逗号时以空格分隔）",
+	"Experimental: upload a file that is still being recorded as it grows, finishing once it stopped growing for -tail-quiet":                     "实验性功能: 上传仍在录制、不断增长的文件，文件停止增长 -tail-quiet 后完成上传",
+	"With -tail, how long the file must stop growing before it is taken as complete":                                                              "配合 -tail，文件停止增长多久后视为录制完成",
+	"Task ID belonging to -upload-token":                                                                                                          "-upload-token 对应的任务 ID",
+	"Time limit for the whole batch (0 means no limit)":                                                                                           "整个批量上传的时间限制（0 表示不限制）",
+	"Video title when publishing (default file name without extension)":                                                                           "投稿时的视频标题（默认为不含扩展名的文件名）",
+	"Title template for batch uploads, e.g. \"{parent} - {name} #{index}\". Placeholders: {name} file name without extension, {index} position in the batch, {date} upload date (YYYY-MM-DD), {parent} name of the parent directory": "批量上传的标题模板，例如 \"{parent} - {name} #{index}\"。占位符: {name} 不含扩展名的文件名，{index} 在批量中的序号，{date} 上传日期（YYYY-MM-DD），{parent} 上级目录的名称",
+	"Directory for temp files of -audio, -clip and -auto-cover (default the system temp dir)":              "-audio、-clip 和 -auto-cover 临时文件所在的目录（默认为系统临时目录）",
+	"Your User Token (a.k.a acPasstoken)":                                                                  "你的用户令牌（即 acPasstoken）",
+	"Log DNS, connect, TLS and time-to-first-byte of every request and summarize them per host":            "记录每个请求的 DNS、连接、TLS 和首字节时间，并按主机汇总",
+	"Your User ID (a.k.a auth_key)":                                                                        "你的用户 ID（即 auth_key）",
+	"Upload to this pre-obtained upload token instead of requesting one, needs -task-id":                   "使用这个预先获取的上传凭证，而不是重新申请，需要 -task-id",
+	"Verbose Mode (also set by ACFUN_DEBUG=1)":                                                             "详细模式（也可以设置 ACFUN_DEBUG=1）",
+	"Keep running and upload every new file that appears in this directory, until interrupted or -timeout": "持续运行，上传这个目录中出现的每个新文件，直到被中断或到达 -timeout",
+	"With -watch, wait until a new file stopped changing for this long before uploading it":                "配合 -watch，新文件停止变化这么久后才上传",
+	"POST JSON progress events (started, progress, completed, failed) of every file to this URL":           "把每个文件的进度事件（started、progress、completed、failed）以 JSON POST 到这个网址",
+}
+
+// language returns "zh" or "en".
+func language() string {
+	if *langOpt != "" {
+		return *langOpt
+	}
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(env); v != "" {
+			if strings.HasPrefix(v, "zh") {
+				return "zh"
+			}
+			return "en"
+		}
+	}
+	return "en"
+}
+
+// tr returns the translation of the English format string s.
+func tr(s string) string {
+	if language() == "zh" {
+		if t, ok := zhMessages[s]; ok {
+			return t
+		}
+	}
+	return s
+}
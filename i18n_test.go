@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var verbRE = regexp.MustCompile(`%[-+# 0]*(\[\d+\])?(\d+|\*)?(\.\d+)?[a-zA-Z%]`)
+
+// verbs returns the verbs of format without their index, sorted, since
+// a translation may reorder its arguments with %[n]s.
+func verbs(format string) []string {
+	var vs []string
+	for _, v := range verbRE.FindAllString(format, -1) {
+		vs = append(vs, regexp.MustCompile(`\[\d+\]`).ReplaceAllString(v, ""))
+	}
+	sort.Strings(vs)
+	return vs
+}
+
+func TestTranslationsKeepVerbs(t *testing.T) {
+	// flag usages are printed as they are, not as formats
+	usages := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) {
+		usages[f.Usage] = true
+	})
+	for en, zh := range zhMessages {
+		if usages[en] {
+			continue
+		}
+		if got, want := strings.Join(verbs(zh), " "), strings.Join(verbs(en), " "); got != want {
+			t.Errorf("%q translated with verbs %q, want %q", en, got, want)
+		}
+		if strings.HasSuffix(en, "\n") != strings.HasSuffix(zh, "\n") {
+			t.Errorf("%q and its translation %q end differently", en, zh)
+		}
+	}
+}
+
+func TestFlagUsagesTranslated(t *testing.T) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] || strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		if _, ok := zhMessages[f.Usage]; !ok {
+			t.Errorf("no translation of the usage of -%s", f.Name)
+		}
+	})
+}
+
+func TestCheckFlagsTranslated(t *testing.T) {
+	setFlag(t, "retry-jitter", "2")
+	for lang, want := range map[string]string{"en": "-retry-jitter must be between 0 and 1", "zh": "-retry-jitter 必须在 0 到 1 之间"} {
+		setFlag(t, "lang", lang)
+		if err := checkFlags(); err == nil || err.Error() != want {
+			t.Errorf("-lang %s: got %v, want %q", lang, err, want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// workerKeys receives +1/-1 for every + or - typed on the terminal while
+// -keys is on, nil otherwise. Stdin is line buffered, so the keys take
+// effect once Enter is pressed.
+var workerKeys chan int
+
+func startKeys() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	workerKeys = make(chan int)
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			switch c {
+			case '+':
+				workerKeys <- 1
+			case '-':
+				workerKeys <- -1
+			}
+		}
+	}()
+	return true
+}
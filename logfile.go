@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// logSegments is the number of rotated log files kept next to the
+// current one, as file.log.1 (newest) to file.log.N (oldest).
+const logSegments = 5
+
+// rotateWriter appends to a log file and rolls it over once it would grow
+// beyond max bytes. A max of 0 disables rotation.
+type rotateWriter struct {
+	mu   sync.Mutex
+	path string
+	max  int64
+	size int64
+	file *os.File
+}
+
+func openLog(path string, max int64) (*rotateWriter, error) {
+	w := &rotateWriter{path: path, max: max}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.max > 0 && w.size > 0 && w.size+int64(len(p)) > w.max {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	segment := func(i int) string {
+		return fmt.Sprintf("%s.%d", w.path, i)
+	}
+	_ = os.Remove(segment(logSegments))
+	for i := logSegments - 1; i >= 1; i-- {
+		if err := os.Rename(segment(i), segment(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, segment(1)); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
@@ -1,21 +1,23 @@
 package main
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/hex"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
@@ -24,28 +26,133 @@ import (
 var (
 	token  = flag.String("token", "", "Your User Token (a.k.a acPasstoken)")
 	uid    = flag.String("uid", "", "Your User ID (a.k.a auth_key)")
-	debug  = flag.Bool("verbose", false, "Verbose Mode")
+	debug  = flag.Bool("verbose", false, "Verbose Mode (also set by ACFUN_DEBUG=1)")
 	auth   string
 	client = http.Client{Timeout: 10 * time.Second}
 )
 
+// coverURL is the uploaded -cover image, shared by every video of the batch.
+var coverURL string
+
+// hook receives the progress events when -webhook is set.
+var hook *Webhook
+
+var (
+	timeout     = flag.Duration("timeout", 0, "Time limit for the whole batch (0 means no limit)")
+	fileTimeout = flag.Duration("file-timeout", 0, "Time limit for each file, the batch moves on when exceeded (0 means no limit)")
+	configPath  = flag.String("config", "", "Config file path (default <user config dir>/acfun-uploader/config.json)")
+	origin      = flag.String("origin", defaultOrigin, "Origin header sent to the AcFun API")
+	referer     = flag.String("referer", defaultReferer, "Referer header sent to the AcFun API, change it if the upload page moves")
+	contentType = flag.String("content-type", "", "Content-Type of video fragments: a MIME type, or auto to guess it from the file extension (default application/octet-stream)")
+	cookieFile  = flag.String("cookie-file", "", "Read token and uid from a Netscape cookies.txt exported from your browser")
+	rawCookie   = flag.String("cookie", "", "Full cookie string copied from the browser, sent as is instead of -token and -uid")
+
+	title    = flag.String("title", "", "Video title when publishing (default file name without extension)")
+	titleTpl = flag.String("title-template", "", titleTemplateHelp)
+	dirTitle = flag.Bool("prefix-with-dir", false, "Prefix every title with the name of the file's directory, e.g. \"Season 1 - ep01\"")
+	channel  = flag.Int("channel", 0, "Channel ID to publish to, uploads without a channel are only added to the video library")
+	tags     = flag.String("tags", "", "Comma separated tags when publishing (space separated if there is no comma)")
+	desc     = flag.String("desc", "", "Video description when publishing")
+	original = flag.Bool("original", true, "Declare the video as original work when publishing (use -original=false for reprints)")
+	declare  = flag.Bool("original-declare", false, "Add the original work declaration (no reposting without permission) to an original video")
+	source   = flag.String("source", "", "Source URL of a reprint, only with -original=false")
+	reprint  = flag.String("reprint-from", "", "AcFun video a reprint comes from, e.g. ac12345, sent as its URL in place of -source")
+
+	noColorOpt = flag.Bool("no-color", false, "Disable colored output (also set by the NO_COLOR environment variable)")
+	jsonOutput = flag.Bool("json", false, "Print the batch summary as JSON to stdout, other messages go to stderr")
+	insecure   = flag.Bool("insecure", false, "Skip TLS certificate verification, only for debugging through an intercepting proxy")
+	printReqs  = flag.Bool("print-requests", false, "Print every outgoing request (credentials redacted) to stderr")
+	traceReqs  = flag.Bool("trace", false, "Log DNS, connect, TLS and time-to-first-byte of every request and summarize them per host")
+	logPath    = flag.String("log", "", "Write log messages to this file instead of stderr")
+	webhookURL = flag.String("webhook", "", "POST JSON progress events (started, progress, completed, failed) of every file to this URL")
+	metricsTo  = flag.String("metrics-file", "", "Write run metrics to this file in Prometheus textfile collector format")
+	logMaxSize = flag.Int64("log-max-size", 0, "Rotate the -log file when it exceeds this many MiB, keeping 5 old segments (0 means no rotation)")
+	draft      = flag.Bool("draft", false, "Save as draft instead of publishing (not supported yet, see README)")
+	replaceID  = flag.Int64("replace", 0, "Replace the media of this published video, keeping its ID and stats (not supported yet, see README)")
+	saveResp   = flag.String("save-response", "", "Save the raw createVideo, uploadFinish and createDouga responses of every upload in this directory")
+	manifestTo = flag.String("manifest-output", "", "Record uploaded files in this manifest (.csv or JSON), files already in it are skipped")
+	recursive  = flag.Bool("recursive", false, "Upload the files inside directories given as arguments")
+	cover      = flag.String("cover", "", "Cover image used when publishing")
+	autoCov    = flag.Bool("auto-cover", false, "Use the frame at 10% of each video as its cover (needs ffmpeg and ffprobe)")
+	coverFit   = flag.Bool("cover-fit", false, "Crop the cover to 16:9 and scale it down to 1280x720 if it is larger")
+	stripMeta  = flag.Bool("strip-metadata", false, "Remux each file without its metadata tags (location, device, comments) before uploading, streams are copied as is (needs ffmpeg)")
+	clip       = flag.String("clip", "", "Upload only this segment of each file, e.g. 00:01:00-00:05:00 (needs ffmpeg and ffprobe)")
+	reencClip  = flag.Bool("reencode-clip", false, "Re-encode -clip segments (H.264/AAC) so they start and end on the exact frames, slower than the default key frame cut")
+	tmpDir     = flag.String("tmp-dir", "", "Directory for temp files of -audio, -clip and -auto-cover (default the system temp dir)")
+	keepTemp   = flag.Bool("keep-temp", false, "Keep the temp files after upload, for debugging")
+	audio      = flag.String("audio", "", "Upload this audio file as a video showing the -cover image (needs ffmpeg)")
+
+	checksumAlgo = flag.String("checksum-algo", "md5", "Hash of whole files recorded in the summary and manifest: md5 or sha256")
+
+	listChans    = flag.Bool("list-channels", false, "List the channels videos can be published to and exit")
+	refreshChans = flag.Bool("refresh-channels", false, "Fetch the channel list again instead of using the one cached for 24h")
+
+	autoParallel = flag.Bool("concurrency-auto", false, "Probe the link with the first fragments and pick the fastest parallelism")
+	keys         = flag.Bool("keys", false, "Type + or - and Enter during an upload to add or remove a worker (terminal only)")
+	stallTimeout = flag.Duration("stall-timeout", 0, "Retry a fragment when its upload sends no data for this long (0 means only the request timeout applies)")
+	parallelSafe = flag.Bool("parallel-safe", false, "Upload fragments one at a time, for accounts that reject concurrent fragments")
+
+	uploadToken = flag.String("upload-token", "", "Upload to this pre-obtained upload token instead of requesting one, needs -task-id")
+	taskID      = flag.String("task-id", "", "Task ID belonging to -upload-token")
+	finishParts = flag.Int64("finish-fragments", 0, "Only finish and publish the upload of -upload-token, whose fragments were all sent by another process, given their count")
+	resume      = flag.Bool("resume", false, "Save upload progress and continue interrupted uploads of the same file")
+	listResume  = flag.Bool("list-resumable", false, "List the interrupted uploads -resume can continue and exit")
+	cleanResume = flag.Bool("clean-resumable", false, "Delete the resume states that can't be resumed any more and exit")
+	resumeFrom  = flag.String("resume-from", "", "Continue the upload saved in this resume state file, copied from another machine along with the file (needs -resume)")
+	retryFailed = flag.Bool("retry-failed", false, "Upload again the files that failed in earlier runs (combine with -resume to keep their progress)")
+	failFast    = flag.Bool("fail-fast", false, "Stop the batch at the first file that fails, the rest are not attempted")
+
+	estimateOnly = flag.Bool("estimate", false, "Print the expected upload time of the files without uploading, needs -link-speed")
+	linkSpeed    = flag.String("link-speed", "", "Upload speed of your link for -estimate, e.g. 10Mbps or 2MB/s")
+	noFinish     = flag.Bool("no-finish", false, "Send the fragments but leave the finishing to a later run, printing the -upload-token, -task-id and -finish-fragments it needs")
+	dryUpload    = flag.Bool("dry-upload", false, "Send the fragments to measure the upload speed, but never finish or publish the upload (leaves an unfinished upload on the server)")
+)
+
+// msg receives the human readable messages, it is switched to stderr in
+// -json mode so that stdout only carries the summary.
+var msg io.Writer = os.Stdout
+
+// noColor reports whether colors are turned off, see https://no-color.org.
+// Output that is not a terminal never gets colors either way.
+func noColor() bool {
+	return *noColorOpt || os.Getenv("NO_COLOR") != ""
+}
+
+// debugEnv turns on -verbose for wrappers that can't add flags.
+const debugEnv = "ACFUN_DEBUG"
+
 const (
 	UploadConfig   = "https://member.acfun.cn/video/api/getKSCloudToken"
 	UploadFinish   = "https://member.acfun.cn/video/api/uploadFinish"
 	CreateVideo    = "https://member.acfun.cn/video/api/createVideo"
+	CreateDouga    = "https://member.acfun.cn/video/api/createDouga"
 	UploadResume   = "https://mediacloud.kuaishou.com/api/upload/resume"
 	UploadEndpoint = "https://mediacloud.kuaishou.com/api/upload/fragment"
 	UploadComplete = "https://mediacloud.kuaishou.com/api/upload/complete"
+
+	presetPartSize = 4 << 20
+	presetParallel = 4
+	maxPartSize    = 1 << 30
 )
 
+// UploadConfigResp is the answer to getKSCloudToken, see UploadAPI. A
+// non-zero Result is returned as an error carrying ErrorMsg. Token is the
+// upload_token of the fragment, resume and complete calls, TaskID names
+// the upload in createVideo and uploadFinish. Host is not used.
 type UploadConfigResp struct {
-	Result int               `json:"result"`
-	Host   string            `json:"host-name"`
-	Config UploadConfigBlock `json:"uploadConfig"`
-	TaskID string            `json:"taskId"`
-	Token  string            `json:"token"`
+	Result   int               `json:"result"`
+	Host     string            `json:"host-name"`
+	Config   UploadConfigBlock `json:"uploadConfig"`
+	TaskID   string            `json:"taskId"`
+	Token    string            `json:"token"`
+	ErrorMsg string            `json:"error_msg"`
 }
 
+// UploadConfigBlock is how the server wants the file sent. Fragments are
+// PartSize-1 bytes long, Parallel of them at a time. RetryCount is the
+// attempts per fragment (0 for no limit) and RetryDurationSeconds the
+// delay before the first retry. check has vetted PartSize and Parallel
+// of every config the API returns.
 type UploadConfigBlock struct {
 	PartSize             int `json:"partSize"`
 	Parallel             int `json:"parallel"`
@@ -53,9 +160,24 @@ type UploadConfigBlock struct {
 	RetryDurationSeconds int `json:"retryDurationSeconds"`
 }
 
+// check rejects a part size the transfer can't work with, and replaces a
+// missing or nonsensical parallelism with the preset one.
+func (c *UploadConfigBlock) check() error {
+	// fragments are PartSize-1 bytes long, and every worker holds one
+	if c.PartSize <= 1 || c.PartSize > maxPartSize {
+		return fmt.Errorf("unusable upload config: part size %d", c.PartSize)
+	}
+	if c.Parallel < 1 {
+		log.Printf("upload config has parallel %d, using %d", c.Parallel, presetParallel)
+		c.Parallel = presetParallel
+	}
+	return nil
+}
+
 type UploadPart struct {
 	content []byte
 	count   int64
+	offset  int64
 }
 
 type UploadPartResult struct {
@@ -64,9 +186,165 @@ type UploadPartResult struct {
 	Size     int64  `json:"size"`
 }
 
+type CreateVideoResp struct {
+	Result   int    `json:"result"`
+	VideoID  int64  `json:"videoId"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+type UploadFinishResp struct {
+	Result   int    `json:"result"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+type CreateDougaResp struct {
+	Result   int    `json:"result"`
+	DougaID  int64  `json:"dougaId"`
+	ErrorMsg string `json:"error_msg"`
+}
+
+// Upload describes a finished upload, DougaID is only set when the video
+// was published. When the transfer ran but the upload failed, an Upload
+// with only Meta and Retries is returned alongside the error.
+type Upload struct {
+	Meta    *VideoMeta
+	VideoID int64
+	DougaID int64
+	Retries int64
+	// Hash is the -checksum-algo hash of the uploaded file, empty when
+	// part of it was uploaded by an earlier run.
+	Hash string
+	// Parallel is the worker count the transfer ended with, taken from
+	// ParallelSource: server, parallel-safe or auto. Serial is set when
+	// it fell back to one fragment at a time.
+	Parallel       int
+	ParallelSource string
+	Serial         bool
+	// Token, TaskID and Fragments are set with -no-finish, they are what
+	// -upload-token, -task-id and -finish-fragments take to finish it.
+	Token     string
+	TaskID    string
+	Fragments int64
+}
+
+type VideoMeta struct {
+	Title    string
+	Cover    string
+	Channel  int
+	Tags     []string
+	Desc     string
+	Original bool
+	// Declare and Source are the accompanying fields of the creation
+	// type: the original work declaration, or where a reprint comes from.
+	Declare bool
+	Source  string
+}
+
 func main() {
+	flag.Usage = printUsage
+	ctx, stop := interruptContext()
+	code := run(ctx)
+	if ctx.Err() != nil {
+		removeAllTemp()
+		fmt.Fprint(os.Stderr, tr("interrupted\n"))
+		code = exitInterrupted
+	}
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context) int {
 	flag.Parse()
 	files := flag.Args()
+	if on, _ := strconv.ParseBool(os.Getenv(debugEnv)); on {
+		*debug = true
+	}
+	if *jsonOutput {
+		msg = os.Stderr
+	}
+	stopProfiles, err := startProfiles()
+	if err != nil {
+		fmt.Fprintf(msg, tr("startProfiles returns error: %v\n"), err)
+		return exitCode(err)
+	}
+	defer stopProfiles()
+
+	if *configInit {
+		path, err := initConfig(*configPath, os.Stdin)
+		if err != nil {
+			fmt.Fprintf(msg, tr("initConfig returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		fmt.Fprintf(msg, tr("Config written to %s\n"), path)
+		return 0
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(msg, tr("loadConfig returns error: %v\n"), err)
+		return exitCode(err)
+	}
+	applyConfig(conf)
+
+	if *logPath != "" {
+		w, err := openLog(*logPath, *logMaxSize<<20)
+		if err != nil {
+			fmt.Fprintf(msg, tr("openLog returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		defer w.Close()
+		log.SetOutput(w)
+	}
+
+	if *cookieFile != "" || *rawCookie != "" {
+		if isFlagSet("token") || isFlagSet("uid") || *cookieFile != "" && *rawCookie != "" {
+			fmt.Fprint(msg, tr("-token/-uid, -cookie-file and -cookie can't be used together\n"))
+			return exitUsage
+		}
+		if *cookieFile != "" {
+			*token, *uid, err = loadCookieFile(*cookieFile)
+		} else {
+			*token, *uid, err = parseCookieHeader(*rawCookie)
+		}
+		if err != nil {
+			fmt.Fprintln(msg, err)
+			return exitUsage
+		}
+	}
+
+	if *diffDir != "" {
+		if *manifestTo == "" {
+			fmt.Fprint(msg, tr("-diff compares with the -manifest-output of earlier uploads, set it as well\n"))
+			return exitUsage
+		}
+		manifest, err := loadManifest(*manifestTo)
+		if err != nil {
+			fmt.Fprintln(msg, err)
+			return exitCode(err)
+		}
+		report, err := diffManifest(manifest, *diffDir)
+		if err != nil {
+			fmt.Fprintf(msg, tr("diffManifest returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		report.Print()
+		return 0
+	}
+	if *listFormats {
+		printFormats()
+		return 0
+	}
+	if *listResume || *cleanResume {
+		states, err := listResumeStates()
+		if err == nil {
+			err = printResumeStates(states, *cleanResume)
+		}
+		if err != nil {
+			fmt.Fprintf(msg, tr("listing resume states returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		return 0
+	}
 
 	if *debug {
 		log.Printf("acPasstoken = %s", *token)
@@ -75,177 +353,868 @@ func main() {
 		log.Printf("files = %s", files)
 	}
 	if *token == "" || *uid == "" {
-		fmt.Println("token or uid is missing")
+		fmt.Fprint(msg, tr("token or uid is missing\n"))
 		printUsage()
-		return
+		return exitUsage
+	}
+	warnings, err := checkCredentials(*token, *uid)
+	if err != nil {
+		fmt.Fprintln(msg, err)
+		return exitUsage
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(msg, tr("warning: %s\n"), w)
+	}
+	if err := checkFlags(); err != nil {
+		fmt.Fprintln(msg, err)
+		return exitUsage
 	}
 	auth = fmt.Sprintf("acPasstoken=%s; auth_key=%s; ", *token, *uid)
+	if *rawCookie != "" {
+		auth = *rawCookie
+	}
 
-	for _, v := range files {
-		fmt.Printf("Local: %s\n", v)
-		if *debug {
-			log.Println("retrieving file info...")
+	if *insecure {
+		fmt.Fprint(os.Stderr, tr("WARNING: -insecure is set, TLS certificates are NOT verified.\n"))
+		fmt.Fprint(os.Stderr, tr("WARNING: your token can be read by anyone between you and AcFun, use it for debugging only.\n"))
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = transport
+	}
+	if err := useCassette(); err != nil {
+		fmt.Fprintln(msg, err)
+		return exitUsage
+	}
+	if *printReqs {
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
 		}
-		info, err := getFileInfo(v)
-		if err != nil {
-			fmt.Printf("getFileInfo returns error: %v", err)
-			continue
+		client.Transport = &printTransport{next: next}
+	}
+	var tracer *traceTransport
+	if *traceReqs {
+		next := client.Transport
+		if next == nil {
+			next = http.DefaultTransport
 		}
+		tracer = newTraceTransport(next)
+		client.Transport = tracer
+	}
 
-		config, err := getUploadConfig(info)
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	var manifest *Manifest
+	if *manifestTo != "" {
+		manifest, err = loadManifest(*manifestTo)
 		if err != nil {
-			fmt.Printf("getUploadConfig returns error: %v", err)
-			continue
+			fmt.Fprintln(msg, err)
+			return exitCode(err)
 		}
+	}
 
-		resumeURL := fmt.Sprintf("%s?upload_token=%s", UploadResume, config.Token)
-		err = uploadRequest("GET", resumeURL)
+	if *recursive {
+		files, err = expandFiles(files)
 		if err != nil {
-			fmt.Printf("uploadRequest returns error: %v", err)
-			continue
+			fmt.Fprintf(msg, tr("expandFiles returns error: %v\n"), err)
+			return exitCode(err)
+		}
+	}
+	if *watch != "" && len(files) > 0 {
+		fmt.Fprint(msg, tr("-watch takes its files from the watched directory, don't pass any\n"))
+		return exitUsage
+	}
+	if *retryFailed {
+		if len(files) > 0 {
+			fmt.Fprint(msg, tr("-retry-failed takes its files from the failed list, don't pass any\n"))
+			return exitUsage
+		}
+		entries, err := loadFailed()
+		if err != nil {
+			fmt.Fprintf(msg, tr("loadFailed returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		for _, e := range entries {
+			fmt.Fprintf(msg, tr("Retrying: %s (failed at %s: %s)\n"), e.Path, e.Time.Format("2006-01-02 15:04"), e.Error)
+			files = append(files, e.Path)
+		}
+		if len(files) == 0 {
+			fmt.Fprint(msg, tr("No failed files to retry\n"))
+			return 0
+		}
+	}
+	if *audio != "" {
+		if len(files) > 0 {
+			fmt.Fprint(msg, tr("-audio uploads a single audio file, don't pass any other files\n"))
+			return exitUsage
 		}
+		dir, err := tempDir("acfun-audio-")
+		if err != nil {
+			fmt.Fprintf(msg, tr("creating temp dir returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		defer removeTemp(dir)
+		fmt.Fprintf(msg, tr("Rendering %s with %s...\n"), *audio, *cover)
+		v, err := audioVideo(ctx, *audio, *cover, dir)
+		if err != nil {
+			fmt.Fprintf(msg, tr("audioVideo returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		files = []string{v}
+	}
+	if *tail && len(files) != 1 {
+		fmt.Fprint(msg, tr("-tail follows a single recording, pass exactly one file\n"))
+		return exitUsage
+	}
+	if *resumeFrom != "" && len(files) != 1 {
+		fmt.Fprint(msg, tr("-resume-from belongs to a single upload, pass exactly one file\n"))
+		return exitUsage
+	}
+	if *uploadToken != "" && len(files) != 1 {
+		fmt.Fprint(msg, tr("-upload-token belongs to a single upload, pass exactly one file\n"))
+		return exitUsage
+	}
 
-		bar := pb.Full.Start64(info.Size())
-		bar.Set(pb.Bytes, true)
-		file, err := os.Open(v)
+	if *listChans {
+		channels, err := getChannels(ctx, *refreshChans)
 		if err != nil {
-			fmt.Printf("openFile returns error: %v", err)
-			continue
+			fmt.Fprintf(msg, tr("getChannels returns error: %v\n"), err)
+			return exitCode(err)
+		}
+		printChannels(channels, "")
+		return 0
+	}
+	if *channel != 0 {
+		if err := checkChannel(ctx, *channel); err != nil {
+			fmt.Fprintln(msg, err)
+			return exitCode(err)
 		}
+	}
 
-		wg := new(sync.WaitGroup)
-		ch := make(chan *UploadPart)
-		for i := 0; i < config.Config.Parallel; i++ {
-			go uploader(config.Token, config.Config.PartSize-1, info.Size(), ch, wg, bar)
+	if *estimateOnly {
+		if err := estimate(ctx, files); err != nil {
+			fmt.Fprintln(msg, err)
+			return exitCode(err)
 		}
+		return 0
+	}
 
-		part := int64(-1)
-		for {
-			part++
-			buf := make([]byte, config.Config.PartSize-1)
-			nr, err := file.Read(buf[:])
-			if nr <= 0 || err != nil {
-				break
+	if *tail {
+		fmt.Fprint(msg, tr("warning: -tail is experimental, the server may reject a growing file\n"))
+	}
+	if *stripMeta && !haveTool("ffmpeg") {
+		fmt.Fprint(msg, tr("warning: ffmpeg not found, -strip-metadata is ignored\n"))
+		*stripMeta = false
+	}
+	if *keys && !startKeys() {
+		fmt.Fprint(msg, tr("warning: -keys is ignored, stdin is not a terminal\n"))
+	}
+
+	if *cover != "" && *channel != 0 {
+		coverURL, err = uploadCover(ctx, *cover)
+		if err != nil {
+			fmt.Fprintf(msg, tr("uploadCover returns error: %v\n"), err)
+			return exitCode(err)
+		}
+	}
+
+	// already validated by checkFlags
+	clipFrom, clipTo, _ := parseClip(*clip)
+
+	if *webhookURL != "" {
+		hook = newWebhook(*webhookURL)
+		defer hook.Close(10 * time.Second)
+	}
+
+	batch := newBatch(files)
+	// once the account is over its quota every other upload fails too
+	quotaHit := false
+	// with -fail-fast the first failed file ends the batch
+	firstFailed, notAttempted := "", 0
+	// the checks of the next file are made before the current one is
+	// uploaded, to know whether to prefetch its config
+	checks := make(map[string]*fileCheck)
+	checkFile := func(v string) *fileCheck {
+		c := new(fileCheck)
+		if c.err = checkSize(v); c.err != nil {
+			return c
+		}
+		w, err := checkDuration(ctx, v, clipFrom, clipTo)
+		if err != nil {
+			c.err = err
+			return c
+		}
+		if w != "" {
+			c.warnings = append(c.warnings, w)
+		}
+		if w := checkFormat(v); w != "" {
+			c.warnings = append(c.warnings, w)
+		}
+		if manifest != nil {
+			if hash, err := hashFile(v); err == nil {
+				c.sum = hash
+				if *clip != "" {
+					hash = clipKey(hash, clipFrom, clipTo)
+				}
+				c.hash, c.entry = hash, manifest.Lookup(hash)
 			}
-			if nr > 0 {
-				wg.Add(1)
-				ch <- &UploadPart{
-					content: buf[:nr],
-					count:   part,
+		}
+		return c
+	}
+	uploadOne := func(i int, v string, pre *Prefetch) {
+		fmt.Fprintf(msg, tr("Local: %s %s\n"), v, batch.Progress(i))
+		if ctx.Err() != nil {
+			batch.Add(v, 0, nil, fmt.Errorf("skipped: %w", timeoutError(ctx, ctx, ctx.Err())))
+			return
+		}
+		if quotaHit {
+			batch.Add(v, 0, nil, fmt.Errorf("skipped: %w by an earlier file", ErrQuotaExceeded))
+			return
+		}
+		if firstFailed != "" {
+			notAttempted++
+			batch.Add(v, 0, nil, fmt.Errorf("skipped: -fail-fast after %s failed", firstFailed))
+			return
+		}
+		if *failFast {
+			defer func() {
+				if batch.Results[len(batch.Results)-1].Err != nil {
+					firstFailed = v
 				}
+			}()
+		}
+		c := checks[v]
+		delete(checks, v)
+		if c == nil {
+			c = checkFile(v)
+		}
+		if c.err != nil {
+			fmt.Fprintln(msg, c.err)
+			batch.Add(v, 0, nil, c.err)
+			return
+		}
+		for _, w := range c.warnings {
+			fmt.Fprintf(msg, tr("warning: %s\n"), w)
+		}
+		// looked up again, the file before may have had the same content
+		hash := c.hash
+		if hash != "" {
+			if e := manifest.Lookup(hash); e != nil {
+				fmt.Fprintf(msg, tr("Skipped: already uploaded as video %d (%s)\n"), e.VideoID, e.Path)
+				batch.Skip(v, e)
+				return
+			}
+		}
+		start := time.Now()
+		meta := videoMeta(v, i+1)
+		if *autoCov {
+			if link, err := autoCover(ctx, v); err != nil {
+				fmt.Fprintf(msg, tr("warning: publishing %s without a cover: %v\n"), v, err)
+			} else {
+				meta.Cover = link
 			}
 		}
+		file, clipDir := v, ""
+		if *clip != "" {
+			var err error
+			clipDir, file, err = clipVideo(ctx, v, clipFrom, clipTo)
+			if err != nil {
+				removeTemp(clipDir)
+				err = fmt.Errorf("clipVideo returns error: %w", err)
+				fmt.Fprintln(msg, err)
+				batch.Add(v, time.Since(start), nil, err)
+				return
+			}
+		}
+		stripDir := ""
+		if *stripMeta {
+			var err error
+			stripDir, file, err = stripMetadata(ctx, file)
+			if err != nil {
+				removeTemp(stripDir)
+				removeTemp(clipDir)
+				err = fmt.Errorf("stripMetadata returns error: %w", err)
+				fmt.Fprintln(msg, err)
+				batch.Add(v, time.Since(start), nil, err)
+				return
+			}
+		}
+		hook.Send(&WebhookEvent{Event: "started", File: v})
+		// the file was hashed by checkFile, unless it was cut or remuxed
+		sum := c.sum
+		if file != v {
+			sum = ""
+		}
+		up, err := uploadFile(ctx, file, sum, meta, pre)
+		removeTemp(stripDir)
+		removeTemp(clipDir)
+		quotaHit = errors.Is(err, ErrQuotaExceeded)
+		if err != nil {
+			fmt.Fprintln(msg, err)
+			hook.Send(&WebhookEvent{Event: "failed", File: v, Error: err.Error()})
+		} else {
+			e := &WebhookEvent{Event: "completed", File: v, Percent: 100, VideoID: up.VideoID}
+			if up.DougaID != 0 {
+				e.URL = dougaURL(up.DougaID)
+			}
+			hook.Send(e)
+		}
+		batch.Add(v, time.Since(start), up, err)
+		if err == nil && manifest != nil && hash != "" && !*dryUpload && !*noFinish {
+			manifest.Add(manifestEntry(v, hash, up))
+			if err := manifest.Save(); err != nil {
+				fmt.Fprintf(msg, tr("saving manifest returns error: %v\n"), err)
+			}
+		}
+	}
 
-		wg.Wait()
-		close(ch)
-		_ = file.Close()
-		bar.Finish()
+	if *watch != "" {
+		err := watchDir(ctx, *watch, *watchSettle, func(v string) bool {
+			batch.Expect(v)
+			uploadOne(len(batch.Results), v, nil)
+			return !quotaHit && firstFailed == ""
+		})
+		if err != nil {
+			fmt.Fprintf(msg, tr("watchDir returns error: %v\n"), err)
+			return exitCode(err)
+		}
+	}
+	var next *Prefetch
+	for i, v := range files {
+		pre := next
+		next = nil
+		// the size of a clip or a stripped file is only known once it is
+		// written, so no prefetching then
+		if i+1 < len(files) && ctx.Err() == nil && *clip == "" && !*stripMeta && !quotaHit && firstFailed == "" {
+			c := checkFile(files[i+1])
+			checks[files[i+1]] = c
+			if c.prefetchable(files[i+1]) {
+				next = prefetchConfig(ctx, files[i+1])
+			}
+		}
+		uploadOne(i, v, pre)
+	}
+	if firstFailed != "" {
+		fmt.Fprintf(msg, tr("Aborted: %s failed and -fail-fast is set, %d file(s) not attempted\n"), firstFailed, notAttempted)
+	}
+	batch.PrintSummary()
+	// a dry or unfinished upload says nothing about whether the files are
+	// on AcFun
+	if !*dryUpload && !*noFinish {
+		if err := updateFailed(batch); err != nil {
+			fmt.Fprintf(msg, tr("updateFailed returns error: %v\n"), err)
+		}
+	}
+	if *metricsTo != "" {
+		if err := writeMetrics(*metricsTo, batch); err != nil {
+			fmt.Fprintf(msg, tr("writeMetrics returns error: %v\n"), err)
+		}
+	}
+	if tracer != nil {
+		tracer.PrintSummary()
+	}
+	return batch.ExitCode()
+}
 
-		if *debug {
-			log.Printf("total number of fragment parts: %d", part)
+// checkFlags rejects flag combinations before anything is uploaded.
+func checkFlags() error {
+	if *audio != "" && *cover == "" {
+		return errors.New(tr("-audio needs a -cover image to show in the video"))
+	}
+	if *audio != "" && *autoCov {
+		return errors.New(tr("-audio and -auto-cover can't be used together"))
+	}
+	if *cover != "" && *channel == 0 && *audio == "" {
+		return errors.New(tr("-cover is only used when publishing, set -channel as well"))
+	}
+	if *autoCov && *channel == 0 {
+		return errors.New(tr("-auto-cover is only used when publishing, set -channel as well"))
+	}
+	if *autoCov && *cover != "" {
+		return errors.New(tr("-cover and -auto-cover can't be used together"))
+	}
+	if *dryUpload && (*resume || *cover != "" || *autoCov || *uploadToken != "") {
+		return errors.New(tr("-dry-upload only measures the transfer, it can't be used with -resume, -cover, -auto-cover or -upload-token"))
+	}
+	if *coverFit && *cover == "" && !*autoCov {
+		return errors.New(tr("-cover-fit needs a -cover or -auto-cover"))
+	}
+	if *autoParallel && *parallelSafe {
+		return errors.New(tr("-concurrency-auto and -parallel-safe can't be used together"))
+	}
+	if *title != "" && *titleTpl != "" {
+		return errors.New(tr("-title and -title-template can't be used together"))
+	}
+	if err := checkTitleTemplate(*titleTpl); err != nil {
+		return err
+	}
+	if _, err := normalizeTags(*tags); err != nil {
+		return err
+	}
+	if (*uploadToken == "") != (*taskID == "") {
+		return errors.New(tr("-upload-token and -task-id must be given together"))
+	}
+	if err := checkWatch(); err != nil {
+		return err
+	}
+	if err := checkTail(); err != nil {
+		return err
+	}
+	if err := selectAPI(); err != nil {
+		return err
+	}
+	if *progressMode != "bar" && *progressMode != "grid" {
+		return fmt.Errorf(tr("-progress must be bar or grid, got %q"), *progressMode)
+	}
+	if *maxSize != "" {
+		if _, err := parseSize(*maxSize); err != nil {
+			return err
+		}
+	}
+	if *minDuration < 0 || *maxDuration < 0 || *maxDuration > 0 && *maxDuration < *minDuration {
+		return errors.New(tr("-min-duration and -max-duration can't be negative, and -max-duration must be above -min-duration"))
+	}
+	if *resume && *uploadToken != "" {
+		return errors.New(tr("-resume can't be combined with -upload-token"))
+	}
+	if *resumeFrom != "" && !*resume {
+		return errors.New(tr("-resume-from continues a saved upload, set -resume as well"))
+	}
+	if *finishParts < 0 || *finishParts > 0 && *uploadToken == "" {
+		return errors.New(tr("-finish-fragments needs -upload-token and -task-id, and a positive fragment count"))
+	}
+	if *finishParts > 0 && (*dryUpload || *noFinish || *clip != "") {
+		return errors.New(tr("-finish-fragments sends no data, it can't be used with -dry-upload, -no-finish or -clip"))
+	}
+	if *noFinish && *dryUpload {
+		return errors.New(tr("-no-finish and -dry-upload can't be used together"))
+	}
+	if *retryAttempts < 0 || *retryBase < 0 || *retryMax < 0 {
+		return errors.New(tr("-retry-attempts, -retry-delay and -retry-max-delay can't be negative"))
+	}
+	if *retryJitter < 0 || *retryJitter > 1 {
+		return errors.New(tr("-retry-jitter must be between 0 and 1"))
+	}
+	if *retryBudget < 0 {
+		return errors.New(tr("-retry-budget can't be negative"))
+	}
+	if *langOpt != "" && *langOpt != "en" && *langOpt != "zh" {
+		return fmt.Errorf(tr("-lang must be en or zh, got %q"), *langOpt)
+	}
+	if *checksumAlgo != "md5" && *checksumAlgo != "sha256" {
+		return fmt.Errorf(tr("-checksum-algo must be md5 or sha256, got %q"), *checksumAlgo)
+	}
+	if err := checkContentType(); err != nil {
+		return err
+	}
+	if *estimateOnly && *linkSpeed == "" {
+		return errors.New(tr("-estimate needs -link-speed, e.g. -link-speed 10Mbps"))
+	}
+	if *declare && !*original {
+		return errors.New(tr("-original-declare only applies to original works, it can't be used with -original=false"))
+	}
+	if *source != "" {
+		if *original {
+			return errors.New(tr("-source is the origin of a reprint, set -original=false as well"))
+		}
+		if u, err := url.Parse(*source); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf(tr("-source must be an http(s) URL, got %q"), *source)
+		}
+	}
+	if *reprint != "" {
+		if *original {
+			return errors.New(tr("-reprint-from is the origin of a reprint, set -original=false as well"))
+		}
+		if *source != "" {
+			return errors.New(tr("-reprint-from and -source both give the origin of the reprint, use one of them"))
+		}
+		if _, err := parseAcID(*reprint); err != nil {
+			return err
+		}
+	}
+	if (*declare || *source != "" || *reprint != "") && *channel == 0 {
+		return errors.New(tr("-original-declare, -source and -reprint-from are only used when publishing, set -channel as well"))
+	}
+	if *clip != "" {
+		if _, _, err := parseClip(*clip); err != nil {
+			return err
+		}
+		if *resume {
+			return errors.New(tr("-clip uploads a new temp file every run, it can't be resumed"))
+		}
+	}
+	if *reencClip && *clip == "" {
+		return errors.New(tr("-reencode-clip changes how -clip cuts, set -clip as well"))
+	}
+	if *stripMeta && *resume {
+		return errors.New(tr("-strip-metadata uploads a new temp file every run, it can't be resumed"))
+	}
+	if *draft {
+		return errors.New(tr("-draft is not supported: no draft state is known in the AcFun publish API, omit -channel to upload into the video library without publishing"))
+	}
+	if *replaceID != 0 {
+		return errors.New(tr("-replace is not supported: no call for swapping the media of a published video is known in the AcFun API, upload the file as a new video and edit the old submission on the website"))
+	}
+	return nil
+}
+
+// uploadFile uploads the file v. sum is its -checksum-algo hash when the
+// caller already read the whole file for it, "" otherwise.
+func uploadFile(parent context.Context, v, sum string, meta *VideoMeta, pre *Prefetch) (*Upload, error) {
+	if *finishParts > 0 {
+		return finishOnly(parent, v, meta)
+	}
+	if *tail {
+		return tailUpload(parent, v, meta)
+	}
+	if *debug {
+		log.Println("retrieving file info...")
+	}
+	info, err := getFileInfo(v)
+	if err != nil {
+		return nil, fmt.Errorf("getFileInfo returns error: %w", err)
+	}
+	file, err := os.Open(v)
+	if err != nil {
+		return nil, fmt.Errorf("openFile returns error: %w", err)
+	}
+	defer file.Close()
+	// the size of the open file is what will be read, the upload config,
+	// the fragment count and every Content-Range total all use it
+	info, err = file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("getFileInfo returns error: %w", err)
+	}
+	return uploadReader(parent, v, file, info.Size(), info, sum, meta, pre)
+}
+
+// uploadReader uploads size bytes read from r under the name v. info is
+// the FileInfo of v when r is that file and nil otherwise, -resume only
+// applies to files. sum is the hash of v, see uploadFile.
+func uploadReader(parent context.Context, v string, r io.ReaderAt, size int64, info os.FileInfo, sum string, meta *VideoMeta, pre *Prefetch) (*Upload, error) {
+	if size <= 0 {
+		return nil, emptyError(v)
+	}
+	ctx := parent
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, *fileTimeout)
+		defer cancel()
+	}
+
+	var state *ResumeState
+	var err error
+	switch {
+	case *resumeFrom != "" && info != nil:
+		state, err = importResumeState(*resumeFrom, v, info, sum)
+		if err != nil {
+			return nil, fmt.Errorf("importResumeState returns error: %w", err)
+		}
+	case *resume && info != nil:
+		state = loadResumeState(v, info)
+	}
+	config := pre.Config(ctx, size)
+	switch {
+	case *uploadToken != "":
+		config = presetConfig()
+	case state != nil:
+		config = state.UploadConfig()
+	}
+	if config == nil {
+		config, err = fetchUploadConfig(ctx, filepath.Base(v), size)
+		if err != nil {
+			return nil, fmt.Errorf("getUploadConfig returns error: %w", timeoutError(parent, ctx, err))
 		}
-		// finish upload
-		err = finishUpload(config.Token, part, config.TaskID, path.Base(v))
+	}
+
+	resumed, err := api.Resume(ctx, config.Token)
+	if err != nil && state != nil && ctx.Err() == nil {
+		fmt.Fprintf(msg, tr("Saved upload of %s can't be resumed (%v), starting over\n"), v, err)
+		state.Remove()
+		state = nil
+		config, err = fetchUploadConfig(ctx, filepath.Base(v), size)
 		if err != nil {
-			fmt.Printf("finishUpload returns error: %v", err)
-			continue
+			return nil, fmt.Errorf("getUploadConfig returns error: %w", timeoutError(parent, ctx, err))
+		}
+		resumed, err = api.Resume(ctx, config.Token)
+	}
+	if err != nil {
+		if *uploadToken != "" {
+			return nil, fmt.Errorf("upload token rejected: %w", timeoutError(parent, ctx, err))
+		}
+		return nil, fmt.Errorf("uploadRequest returns error: %w", timeoutError(parent, ctx, err))
+	}
+	if *resume && info != nil && state == nil {
+		state, err = newResumeState(v, info, config, sum)
+		if err == nil {
+			err = state.Save()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("saving resume state returns error: %w", err)
+		}
+	} else if state != nil {
+		if resumed.FragmentList != nil {
+			state.Sync(resumed.FragmentList)
 		}
+		fmt.Fprintf(msg, tr("Resuming: %d of %d fragments already uploaded\n"), len(state.Done), state.Fragments)
+	}
+
+	// sizes and offsets are int64 throughout, so files over 2GiB work on
+	// 32-bit builds too
+	partSize := int64(config.Config.PartSize - 1)
+	bar := pb.Full.New(0).SetTotal(size).Set(pb.Bytes, true)
+	if noColor() {
+		bar.Set(pb.Color, false)
+	}
+	// the grid takes the bar's place, the bar still counts the bytes
+	grid := useGrid()
+	if !grid {
+		bar.Start()
+	}
+	// a resumed upload starts where the confirmed fragments end
+	bar.SetCurrent(state.ConfirmedBytes(partSize))
+	defer bar.Finish()
+	defer hook.Progress(v, bar)()
+
+	t := newTransfer(ctx, config.Token, partSize, size, bar)
+	t.state = state
+	if grid {
+		t.grid = startGrid(int64(len(t.confirmed)), bar)
+	}
+	t.contentType = fragmentContentType(v)
+	t.hash = newHash()
+	t.retry = retryPolicy(config.Config.RetryCount, time.Duration(config.Config.RetryDurationSeconds)*time.Second)
+	parallel, source := config.Config.Parallel, "server"
+	switch {
+	case *parallelSafe:
+		parallel, source = 1, "parallel-safe"
+	case *autoParallel:
+		source = "auto"
+	}
+	started := time.Now()
+	part, err := t.Run(r, parallel)
+	elapsed := time.Since(started)
+	t.grid.Stop()
+	bar.Finish()
+	if *debug {
+		t.rates.Log(v)
+	}
+	// returned along with the errors below, the transfer did happen
+	stats := &Upload{
+		Meta:           meta,
+		Retries:        t.Retries(),
+		Parallel:       t.Workers(),
+		ParallelSource: source,
+		Serial:         t.Serial(),
+	}
+
+	if ctx.Err() != nil {
+		return stats, fmt.Errorf("upload aborted: %w", timeoutError(parent, ctx, ctx.Err()))
+	}
+	if err != nil {
+		return stats, fmt.Errorf("upload aborted, %s is not finished: %w", v, err)
+	}
+
+	if *debug {
+		log.Printf("total number of fragment parts: %d", part)
+	}
+	if state != nil && part != state.Fragments {
+		return stats, fmt.Errorf("upload aborted: %s has %d fragments, expected %d", v, part, state.Fragments)
+	}
+	// the server expects fragments 0..part-1 without gaps
+	if missing := t.Missing(); len(missing) > 0 {
+		return stats, fmt.Errorf("upload aborted: %d fragment(s) of %s were never confirmed: %v", len(missing), v, missing)
 	}
+	if *dryUpload {
+		fmt.Fprintf(msg, tr("Dry upload: %s sent in %v, %s, the upload is left unfinished\n"),
+			v, elapsed.Round(time.Millisecond), formatRate(float64(size)/elapsed.Seconds()))
+		return stats, nil
+	}
+	if *noFinish {
+		stats.Token, stats.TaskID, stats.Fragments = config.Token, config.TaskID, part
+		fmt.Fprintf(msg, tr("Transferred: %s, finish it with -upload-token %s -task-id %s -finish-fragments %d\n"),
+			v, config.Token, config.TaskID, part)
+		return stats, nil
+	}
+	// finish upload
+	up, err := finishUpload(ctx, config.Token, part, config.TaskID, path.Base(v), meta)
+	if err != nil {
+		return stats, fmt.Errorf("finishUpload returns error: %w", timeoutError(parent, ctx, err))
+	}
+	state.Remove()
+	up.Retries = stats.Retries
+	up.Parallel, up.ParallelSource, up.Serial = stats.Parallel, stats.ParallelSource, stats.Serial
+	// the streamed hash misses the fragments of an earlier session
+	switch {
+	case t.hash != nil:
+		up.Hash = formatHash(t.hash)
+	case sum != "":
+		up.Hash = sum
+	case state != nil:
+		up.Hash = state.Hash
+	}
+	return up, nil
 }
 
-func printUsage() {
-	fmt.Printf("Usage of %s:\n", os.Args[0])
-	flag.PrintDefaults()
+// finishOnly runs the finish steps for an upload whose fragments were
+// sent elsewhere. It needs the upload token and task ID the fragments
+// went to and their count, v only names the video and need not exist.
+func finishOnly(parent context.Context, v string, meta *VideoMeta) (*Upload, error) {
+	ctx := parent
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, *fileTimeout)
+		defer cancel()
+	}
+	up, err := finishUpload(ctx, *uploadToken, *finishParts, *taskID, path.Base(v), meta)
+	if err != nil {
+		return nil, fmt.Errorf("finishUpload returns error: %w", timeoutError(parent, ctx, err))
+	}
+	return up, nil
+}
+
+func fetchUploadConfig(ctx context.Context, name string, size int64) (config *UploadConfigResp, err error) {
+	err = retry(ctx, "upload config", retryPolicy(controlRetries, retryDelay), func() error {
+		config, err = api.UploadConfig(ctx, name, size)
+		return err
+	})
+	return config, err
+}
+
+// UploadResumeResp is the answer to the resume call. Its fields are not
+// documented, FragmentList is taken to list the fragments the server
+// already has and is nil when the response doesn't carry it.
+type UploadResumeResp struct {
+	Result       int     `json:"result"`
+	FragmentList []int64 `json:"fragment_list"`
 }
 
-func uploader(token string, partSize int, fileSize int64, ch chan *UploadPart, wg *sync.WaitGroup, bar *pb.ProgressBar) {
-	for item := range ch {
+func resumeUpload(ctx context.Context, token string) (*UploadResumeResp, error) {
+	resumeURL := fmt.Sprintf("%s?upload_token=%s", UploadResume, token)
+	res := new(UploadResumeResp)
+	err := retry(ctx, "upload resume", retryPolicy(controlRetries, retryDelay), func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", resumeURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
 		if *debug {
-			log.Printf("part %d start uploading", item.count)
-		}
-		var md5Hash string
-		var md5Wg sync.WaitGroup
-		md5Wg.Add(1)
-		go func() {
-			defer md5Wg.Done()
-			sum := md5.Sum(item.content)
-			md5Hash = hex.EncodeToString(sum[:])
-		}()
-		postURL := fmt.Sprintf("%s?upload_token=%s&fragment_id=%d", UploadEndpoint, token, item.count)
-		start := item.count * int64(partSize)
-		contentRange := fmt.Sprintf("bytes %d-%d/%d", start, start+int64(len(item.content))-1, fileSize)
-		for {
-			data := new(bytes.Buffer)
-			data.Write(item.content)
-			req, err := http.NewRequest("POST", postURL, data)
-			if err != nil {
-				continue
-			}
-			req.Header.Set("Content-Type", "application/octet-stream")
-			req.Header.Set("Content-Range", contentRange)
-			if *debug {
-				log.Println(req.Header)
-			}
-			checksum, err := upload(req, item.count, len(item.content))
-			md5Wg.Wait()
-			if err != nil {
-				log.Printf("%v", err)
-				time.Sleep(time.Second)
-				continue
-			}
-			if md5Hash != checksum {
-				log.Printf("part %d checksum is wrong: %s, %s", item.count, md5Hash, checksum)
-				time.Sleep(time.Second)
-				continue
-			}
-			break
+			log.Printf("upload resume response: %s", string(body))
+		}
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("upload request returns status %s", resp.Status)
 		}
+		// only the status decides whether the token is usable, the body
+		// is extra information
+		if err := json.Unmarshal(body, res); err != nil && *debug {
+			log.Printf("unmarshaling upload resume response returns error: %v", err)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// timeoutError replaces err with a readable message when it was caused by
+// either the batch or the per-file deadline.
+func timeoutError(parent, ctx context.Context, err error) error {
+	switch {
+	case parent.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("batch timeout (%v) exceeded", *timeout)
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("file timeout (%v) exceeded", *fileTimeout)
+	case parent.Err() == context.Canceled:
+		return fmt.Errorf("interrupted")
+	}
+	return err
+}
+
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), tr("Usage of %s:\n"), os.Args[0])
+	printDefaults()
+}
 
-		bar.Add(len(item.content))
-		wg.Done()
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
 	}
 }
 
 func upload(req *http.Request, count int64, length int) (string, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed uploading part %d error: %v (retring)", count, err)
+		return "", &FragmentError{Part: count, Err: fmt.Errorf("failed uploading part %d error: %v (retring)", count, err)}
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed reading upload part %d response error: %v (retring)", count, err)
+		return "", &FragmentError{Part: count, Err: fmt.Errorf("failed reading upload part %d response error: %v (retring)", count, err)}
 	}
 	if *debug {
 		log.Printf("upload part %d finished. Result: %s", count, string(body))
 	}
+	if err := checkJSON(resp, body); err != nil {
+		return "", &FragmentError{Part: count, Err: fmt.Errorf("failed uploading part %d: %v (retring)", count, err)}
+	}
 	result := new(UploadPartResult)
 	err = json.Unmarshal(body, result)
 	if err != nil {
-		return "", fmt.Errorf("failed unmarshaling upload part %d response to json error: %v (retring)", count, err)
+		return "", &FragmentError{Part: count, Err: fmt.Errorf("failed unmarshaling upload part %d response to json error: %v (retring)", count, err)}
 	}
 	if result.Result != 1 || result.Size != int64(length) {
-		return "", fmt.Errorf("failed uploading part %d response: %+v (retring)", count, *result)
+		return "", &FragmentError{Part: count, Err: fmt.Errorf("failed uploading part %d response: %+v (retring)", count, *result)}
 	}
 
 	return result.Checksum, nil
 }
 
-func finishUpload(token string, part int64, task string, filename string) error {
-	if *debug {
-		log.Println("finishing upload...")
-		log.Println("step1 -> api/uploadComplete")
+// videoMeta builds the metadata of the index-th file of the batch.
+func videoMeta(file string, index int) *VideoMeta {
+	meta := &VideoMeta{
+		Title:    *title,
+		Cover:    coverURL,
+		Channel:  *channel,
+		Desc:     *desc,
+		Original: *original,
+		Declare:  *declare,
+		Source:   *source,
 	}
-	completeURL := fmt.Sprintf("%s?fragment_count=%d&upload_token=%s", UploadComplete, part, token)
-	err := uploadRequest("POST", completeURL)
-	if err != nil {
-		log.Printf("uploadRequest returns error: %v", err)
-		return err
+	if *reprint != "" {
+		// already validated by checkFlags, the publish API only takes a link
+		id, _ := parseAcID(*reprint)
+		meta.Source = dougaURL(id)
 	}
-
-	if *debug {
-		log.Println("step2 -> api/createVideo")
+	if *titleTpl != "" {
+		meta.Title = renderTitle(*titleTpl, file, index)
 	}
+	if meta.Title == "" {
+		// only the file name, however deep -recursive found it
+		base := filepath.Base(file)
+		meta.Title = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if *dirTitle {
+		meta.Title = parentDir(file) + " - " + meta.Title
+	}
+	// already validated by checkFlags
+	meta.Tags, _ = normalizeTags(*tags)
+	return meta
+}
+
+// createVideo turns the uploaded fragments of task into a video named
+// filename in the video library.
+func createVideo(ctx context.Context, task string, filename string) (*CreateVideoResp, error) {
 	data := url.Values{
 		"videoKey": []string{task},
 		"fileName": []string{filename},
@@ -255,70 +1224,245 @@ func finishUpload(token string, part int64, task string, filename string) error
 		log.Printf("postBody: %v", data.Encode())
 		log.Printf("endpoint: %s", CreateVideo)
 	}
-	_, err = request(CreateVideo, data.Encode())
+	body, err := request(ctx, CreateVideo, data.Encode())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	saveResponse(task, "createVideo", body)
+	video := new(CreateVideoResp)
+	err = json.Unmarshal(body, video)
+	if err != nil {
+		return nil, err
 	}
+	return video, nil
+}
 
-	if *debug {
-		log.Println("step3 -> api/uploadFinish")
+// nameTaken reports whether a createVideo error message says the file
+// name is already in use. The exact wording has not been seen, so the
+// usual phrasings are matched.
+func nameTaken(errorMsg string) bool {
+	m := strings.ToLower(errorMsg)
+	for _, phrase := range []string{"重复", "已存在", "duplicate", "already exist"} {
+		if strings.Contains(m, phrase) {
+			return true
+		}
 	}
-	data = url.Values{"taskId": []string{task}}
+	return false
+}
+
+// uniqueName adds the current time to filename, before its extension.
+func uniqueName(filename string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + time.Now().Format("-20060102-150405") + ext
+}
+
+// uploadFinish closes task once its video is created.
+func uploadFinish(ctx context.Context, task string) (*UploadFinishResp, error) {
+	data := url.Values{"taskId": []string{task}}
 	if *debug {
 		log.Printf("postBody: %v", data.Encode())
 		log.Printf("endpoint: %s", UploadFinish)
 	}
-	_, err = request(UploadFinish, data.Encode())
+	body, err := request(ctx, UploadFinish, data.Encode())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	saveResponse(task, "uploadFinish", body)
+	finish := new(UploadFinishResp)
+	err = json.Unmarshal(body, finish)
+	if err != nil {
+		return nil, err
+	}
+	return finish, nil
+}
+
+func finishUpload(ctx context.Context, token string, part int64, task string, filename string, meta *VideoMeta) (*Upload, error) {
+	if *debug {
+		log.Println("finishing upload...")
+		log.Println("step1 -> api/uploadComplete")
+	}
+	err := api.Complete(ctx, token, part)
+	if err != nil {
+		log.Printf("uploadRequest returns error: %v", err)
+		return nil, err
 	}
 
-	return nil
+	if *debug {
+		log.Println("step2 -> api/createVideo")
+	}
+	video, err := api.CreateVideo(ctx, task, filename)
+	if err != nil {
+		return nil, err
+	}
+	if video.Result != 0 && nameTaken(video.ErrorMsg) {
+		unique := uniqueName(filename)
+		log.Printf("createVideo rejected file name %s (%s), retrying as %s", filename, video.ErrorMsg, unique)
+		video, err = api.CreateVideo(ctx, task, unique)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if video.Result != 0 {
+		return nil, resultError("createVideo", video.Result, video.ErrorMsg)
+	}
+	warnResponse("createVideo", video.ErrorMsg)
+
+	if *debug {
+		log.Println("step3 -> api/uploadFinish")
+	}
+	finish, err := api.UploadFinish(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	if finish.Result != 0 {
+		return nil, resultError("uploadFinish", finish.Result, finish.ErrorMsg)
+	}
+	warnResponse("uploadFinish", finish.ErrorMsg)
+
+	up := &Upload{Meta: meta, VideoID: video.VideoID}
+	if meta.Channel == 0 {
+		return up, nil
+	}
+	if *debug {
+		log.Println("step4 -> api/createDouga")
+	}
+	up.DougaID, err = api.Publish(ctx, task, video.VideoID, meta)
+	if err != nil {
+		return nil, err
+	}
+	return up, nil
+}
+
+func publishVideo(ctx context.Context, task string, videoID int64, meta *VideoMeta) (int64, error) {
+	tagNames, err := json.Marshal(meta.Tags)
+	if err != nil {
+		return 0, err
+	}
+	if meta.Tags == nil {
+		tagNames = []byte("[]")
+	}
+	videoInfos, err := json.Marshal([]map[string]interface{}{
+		{"videoId": videoID, "title": meta.Title},
+	})
+	if err != nil {
+		return 0, err
+	}
+	creationType := "1"
+	if meta.Original {
+		creationType = "3"
+	}
+	data := url.Values{
+		"title":           []string{meta.Title},
+		"description":     []string{meta.Desc},
+		"tagNames":        []string{string(tagNames)},
+		"creationType":    []string{creationType},
+		"channelId":       []string{strconv.Itoa(meta.Channel)},
+		"videoInfos":      []string{string(videoInfos)},
+		"isJoinUpCollege": []string{"0"},
+	}
+	if meta.Cover != "" {
+		data.Set("coverUrl", meta.Cover)
+	}
+	if meta.Declare {
+		data.Set("originalDeclare", "1")
+	}
+	if meta.Source != "" {
+		data.Set("originalLinkUrl", meta.Source)
+	}
+	body, err := request(ctx, CreateDouga, data.Encode())
+	if err != nil {
+		return 0, err
+	}
+	saveResponse(task, "createDouga", body)
+	douga := new(CreateDougaResp)
+	err = json.Unmarshal(body, douga)
+	if err != nil {
+		return 0, err
+	}
+	if douga.Result != 0 {
+		return 0, resultError("createDouga", douga.Result, douga.ErrorMsg)
+	}
+	fmt.Fprintf(msg, tr("Published: %s\n"), dougaURL(douga.DougaID))
+	return douga.DougaID, nil
+}
+
+func dougaURL(id int64) string {
+	return fmt.Sprintf("https://www.acfun.cn/v/ac%d", id)
 }
 
-func getUploadConfig(info os.FileInfo) (*UploadConfigResp, error) {
+var acIDPattern = regexp.MustCompile(`^(?i:ac)?([0-9]+)$`)
+
+// parseAcID parses a video ID as shown on AcFun, ac12345 or just 12345.
+func parseAcID(s string) (int64, error) {
+	m := acIDPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("%q is not an AcFun video ID like ac12345", s)
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%q is not an AcFun video ID like ac12345", s)
+	}
+	return id, nil
+}
+
+// getUploadConfig asks for an upload of size bytes named name, size must
+// be the number of bytes that will actually be sent.
+func getUploadConfig(ctx context.Context, name string, size int64) (*UploadConfigResp, error) {
 
 	if *debug {
 		log.Println("retrieving upload config...")
 	}
 	data := url.Values{
-		"fileName": []string{info.Name()},
-		"size":     []string{strconv.FormatInt(info.Size(), 10)},
+		"fileName": []string{name},
+		"size":     []string{strconv.FormatInt(size, 10)},
 		"template": []string{"1"},
 	}
-	body, err := request(UploadConfig, data.Encode())
+	body, err := request(ctx, UploadConfig, data.Encode())
 	if err != nil {
 		return nil, err
 	}
-	config := new(UploadConfigResp)
-	err = json.Unmarshal(body, config)
+	config, err := parseUploadConfig(body)
 	if err != nil {
 		return nil, err
 	}
+	if config.TaskID == "" {
+		return nil, fmt.Errorf("getKSCloudToken returns no task ID")
+	}
 	return config, nil
 }
 
-func request(link string, postBody string) ([]byte, error) {
+// parseUploadConfig decodes the response of a getKSCloudToken call, of
+// videos or of covers, and rejects one that can't be uploaded with.
+func parseUploadConfig(body []byte) (*UploadConfigResp, error) {
+	config := new(UploadConfigResp)
+	if err := json.Unmarshal(body, config); err != nil {
+		return nil, err
+	}
+	if config.Result != 0 {
+		return nil, resultError("getKSCloudToken", config.Result, config.ErrorMsg)
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("getKSCloudToken returns no upload token")
+	}
+	if err := config.Config.check(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func request(ctx context.Context, link string, postBody string) ([]byte, error) {
 	if *debug {
 		log.Printf("postBody: %v", postBody)
 		log.Printf("endpoint: %s", link)
 	}
-	req, err := http.NewRequest("POST", link, strings.NewReader(postBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", link, strings.NewReader(postBody))
 	if err != nil {
 		if *debug {
 			log.Printf("build request returns error: %v", err)
 		}
 		return nil, err
 	}
-	req.Header.Set("authority", "member.acfun.cn")
-	req.Header.Set("host", "member.acfun.cn:443")
-	req.Header.Set("content-type", "application/x-www-form-urlencoded")
-	req.Header.Set("accept", "application/json, text/plain, */*")
-	req.Header.Set("origin", "https://member.acfun.cn")
-	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) "+
-		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.149 Safari/537.36")
-	req.Header.Set("referer", "https://member.acfun.cn/upload-video")
-	req.Header.Set("cookie", auth)
+	setAPIHeaders(req)
 	if *debug {
 		log.Println(req.Header)
 	}
@@ -330,6 +1474,9 @@ func request(link string, postBody string) ([]byte, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: %s returns %s", ErrAuthFailed, link, resp.Status)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		if *debug {
@@ -340,6 +1487,9 @@ func request(link string, postBody string) ([]byte, error) {
 	if *debug {
 		log.Printf("returns: %v", string(body))
 	}
+	if err := checkJSON(resp, body); err != nil {
+		return nil, err
+	}
 	return body, nil
 }
 
@@ -348,11 +1498,55 @@ func getFileInfo(path string) (os.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory; pass -recursive to upload its contents", path)
+	}
+	if info.Size() == 0 {
+		return nil, emptyError(path)
+	}
 	return info, nil
 }
 
-func uploadRequest(method string, link string) error {
-	req, err := http.NewRequest(method, link, nil)
+// emptyError refuses a file without content, the server only rejects its
+// upload at the complete call, which would report 0 fragments.
+func emptyError(path string) error {
+	return fmt.Errorf("%s is empty, there is nothing to upload", path)
+}
+
+// expandFiles replaces every directory in files with the regular files
+// below it, skipping hidden entries.
+func expandFiles(files []string) ([]string, error) {
+	var expanded []string
+	for _, v := range files {
+		info, err := os.Stat(v)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, v)
+			continue
+		}
+		err = filepath.Walk(v, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != v && strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() {
+				expanded = append(expanded, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func uploadRequest(ctx context.Context, method string, link string) error {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
 	if err != nil {
 		if *debug {
 			log.Printf("upload request returns err: %v", err)
@@ -375,5 +1569,21 @@ func uploadRequest(method string, link string) error {
 		}
 		log.Printf("upload request response: %s", string(body))
 	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("upload request returns status %s", resp.Status)
+	}
 	return nil
 }
+
+// presetConfig stands in for the upload config when the token and task
+// come from -upload-token and -task-id.
+func presetConfig() *UploadConfigResp {
+	return &UploadConfigResp{
+		TaskID: *taskID,
+		Token:  *uploadToken,
+		Config: UploadConfigBlock{
+			PartSize: presetPartSize + 1,
+			Parallel: presetParallel,
+		},
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setFlag sets the flag name to value for the rest of the test. The flag
+// does not count as given on the command line, see isFlagSet.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatalf("-%s %s: %v", name, value, err)
+	}
+	t.Cleanup(func() { _ = f.Value.Set(old) })
+}
+
+// testServer starts a server for handler and points client at it: every
+// request goes there whatever its host, the paths of the endpoints stay.
+func testServer(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	old := client.Transport
+	client.Transport = &redirectTransport{host: srv.Listener.Addr().String()}
+	t.Cleanup(func() {
+		client.Transport = old
+		srv.Close()
+	})
+	return srv
+}
+
+type redirectTransport struct {
+	host string
+}
+
+func (r *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = "http", r.host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestUploadConfigCheck(t *testing.T) {
+	tests := []struct {
+		config   UploadConfigBlock
+		parallel int
+		err      bool
+	}{
+		{config: UploadConfigBlock{PartSize: 1048577, Parallel: 4}, parallel: 4},
+		{config: UploadConfigBlock{PartSize: 2, Parallel: 1}, parallel: 1},
+		{config: UploadConfigBlock{PartSize: 1048577, Parallel: 0}, parallel: presetParallel},
+		{config: UploadConfigBlock{PartSize: 1048577, Parallel: -3}, parallel: presetParallel},
+		{config: UploadConfigBlock{PartSize: 0, Parallel: 4}, err: true},
+		{config: UploadConfigBlock{PartSize: 1, Parallel: 4}, err: true},
+		{config: UploadConfigBlock{PartSize: -1048577, Parallel: 4}, err: true},
+		{config: UploadConfigBlock{PartSize: maxPartSize + 1, Parallel: 4}, err: true},
+	}
+	for _, tt := range tests {
+		c := tt.config
+		err := c.check()
+		if (err != nil) != tt.err {
+			t.Errorf("%+v: got error %v, want error %v", tt.config, err, tt.err)
+			continue
+		}
+		if err == nil && c.Parallel != tt.parallel {
+			t.Errorf("%+v: parallel %d, want %d", tt.config, c.Parallel, tt.parallel)
+		}
+	}
+}
+
+func TestGetUploadConfigFailure(t *testing.T) {
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"result":0,"taskId":"task","token":"tok","uploadConfig":{"partSize":1048577,"parallel":4}}`, ""},
+		{`{"result":120002,"error_msg":"文件大小超出限制"}`, "文件大小超出限制"},
+		{`{"result":0,"taskId":"task","uploadConfig":{"partSize":1048577,"parallel":4}}`, "no upload token"},
+		{`{"result":0,"token":"tok","uploadConfig":{"partSize":1048577,"parallel":4}}`, "no task ID"},
+		{`{"result":0,"taskId":"task","token":"tok","uploadConfig":{"partSize":0,"parallel":4}}`, "part size 0"},
+		{`{"result":0,"taskId":"task","token":"tok"}`, "part size 0"},
+	}
+	var body string
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	for _, tt := range tests {
+		body = tt.body
+		config, err := getUploadConfig(context.Background(), "a.mp4", 100)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: returns error: %v", tt.body, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: got %+v, want an error", tt.body, config)
+		case err != nil && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: got %v, want an error about %q", tt.body, err, tt.err)
+		}
+	}
+}
+
+// endpointPath returns the path of an endpoint constant, for routing the
+// requests of testServer.
+func endpointPath(t *testing.T, endpoint string) string {
+	t.Helper()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path
+}
+
+func TestFinishUploadNameTaken(t *testing.T) {
+	tests := []struct {
+		name     string
+		errorMsg string
+		names    int
+		err      bool
+	}{
+		{name: "name taken", errorMsg: "文件名重复", names: 2},
+		{name: "name taken in English", errorMsg: "file name already exists", names: 2},
+		{name: "other error", errorMsg: "视频处理失败", names: 1, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var names []string
+			mux := http.NewServeMux()
+			mux.HandleFunc(endpointPath(t, UploadComplete), func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"result":1}`)
+			})
+			mux.HandleFunc(endpointPath(t, CreateVideo), func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				names = append(names, r.FormValue("fileName"))
+				if len(names) == 1 {
+					fmt.Fprintf(w, `{"result":1,"error_msg":%q}`, tt.errorMsg)
+					return
+				}
+				fmt.Fprint(w, `{"result":0,"videoId":42}`)
+			})
+			mux.HandleFunc(endpointPath(t, UploadFinish), func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"result":0}`)
+			})
+			testServer(t, mux)
+
+			up, err := finishUpload(context.Background(), "tok", 3, "task", "ep01.mp4", &VideoMeta{})
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(names) != tt.names {
+				t.Fatalf("createVideo called with %q, want %d call(s)", names, tt.names)
+			}
+			if names[0] != "ep01.mp4" {
+				t.Errorf("first createVideo has file name %q, want ep01.mp4", names[0])
+			}
+			if tt.err {
+				return
+			}
+			if names[1] == names[0] || !strings.HasPrefix(names[1], "ep01-") || !strings.HasSuffix(names[1], ".mp4") {
+				t.Errorf("retried createVideo with file name %q, want a unique ep01-*.mp4", names[1])
+			}
+			if up.VideoID != 42 {
+				t.Errorf("got video %d, want 42", up.VideoID)
+			}
+		})
+	}
+}
+
+// fakeAcFun answers the upload API the way the real one was seen to, for
+// an upload config of partSize and parallel. Fragments are checked against
+// their Content-Range and answered with their MD5.
+type fakeAcFun struct {
+	partSize int
+	parallel int
+	// resume is the fragment_list of the resume response, none if empty
+	resume string
+	// fail is asked before every fragment attempt, numbered from 1, and
+	// refuses it with a 500 when it returns true
+	fail func(part int64, attempt int) bool
+	// hold is called before a fragment is answered, done after
+	hold func(part int64)
+	done func(part int64)
+
+	mu        sync.Mutex
+	attempts  map[int64]int
+	ranges    map[int64]string
+	order     []int64
+	completes []string
+}
+
+func (f *fakeAcFun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/video/api/getKSCloudToken":
+		fmt.Fprintf(w, `{"result":0,"taskId":"task","token":"tok","uploadConfig":{"partSize":%d,"parallel":%d}}`,
+			f.partSize, f.parallel)
+	case "/api/upload/resume":
+		if f.resume == "" {
+			fmt.Fprint(w, `{"result":1}`)
+			return
+		}
+		fmt.Fprintf(w, `{"result":1,"fragment_list":%s}`, f.resume)
+	case "/api/upload/fragment":
+		part, err := strconv.ParseInt(r.URL.Query().Get("fragment_id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.attempts == nil {
+			f.attempts, f.ranges = make(map[int64]int), make(map[int64]string)
+		}
+		f.attempts[part]++
+		attempt := f.attempts[part]
+		f.mu.Unlock()
+		if f.fail != nil && f.fail(part, attempt) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end); err != nil || end-start+1 != int64(len(body)) {
+			http.Error(w, "bad Content-Range "+r.Header.Get("Content-Range"), http.StatusBadRequest)
+			return
+		}
+		if f.hold != nil {
+			f.hold(part)
+		}
+		f.mu.Lock()
+		f.ranges[part] = r.Header.Get("Content-Range")
+		f.order = append(f.order, part)
+		f.mu.Unlock()
+		sum := md5.Sum(body)
+		fmt.Fprintf(w, `{"result":1,"checksum":"%s","size":%d}`, hex.EncodeToString(sum[:]), len(body))
+		if f.done != nil {
+			f.done(part)
+		}
+	case "/api/upload/complete":
+		f.mu.Lock()
+		f.completes = append(f.completes, r.URL.Query().Get("fragment_count"))
+		f.mu.Unlock()
+		fmt.Fprint(w, `{"result":1}`)
+	case "/video/api/createVideo":
+		fmt.Fprint(w, `{"result":0,"videoId":42}`)
+	case "/video/api/uploadFinish":
+		fmt.Fprint(w, `{"result":0}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Fragments returns the fragments accepted so far, in the order they
+// were answered.
+func (f *fakeAcFun) Fragments() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64(nil), f.order...)
+}
+
+// Completes returns the fragment_count of every complete call.
+func (f *fakeAcFun) Completes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.completes...)
+}
+
+// useStateDir keeps the resume states of the test in a temp dir.
+func useStateDir(t *testing.T) {
+	t.Helper()
+	old, ok := os.LookupEnv("XDG_CACHE_HOME")
+	if err := os.Setenv("XDG_CACHE_HOME", t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv("XDG_CACHE_HOME", old)
+		} else {
+			_ = os.Unsetenv("XDG_CACHE_HOME")
+		}
+	})
+}
+
+func TestUploadReadError(t *testing.T) {
+	f := &fakeAcFun{partSize: 1001, parallel: 2}
+	testServer(t, f)
+
+	size := int64(5000)
+	r := &failingReader{r: bytes.NewReader(testContent(size)), failAt: 2500}
+	_, err := uploadReader(context.Background(), "video.mp4", r, size, nil, "", &VideoMeta{}, nil)
+	if err == nil || !strings.Contains(err.Error(), errDisk.Error()) {
+		t.Fatalf("got %v, want the read error", err)
+	}
+	if completes := f.Completes(); len(completes) > 0 {
+		t.Errorf("a file that couldn't be read was completed with %v fragments", completes)
+	}
+}
+
+// openFiles counts the descriptors of the process open on files in dir.
+func openFiles(t *testing.T, dir string) int {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("can't list open files: %v", err)
+	}
+	n := 0
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUploadFileCloses(t *testing.T) {
+	tests := []struct {
+		name string
+		f    *fakeAcFun
+		err  bool
+	}{
+		{name: "uploaded", f: &fakeAcFun{partSize: 1001, parallel: 2}},
+		{name: "config refused", f: &fakeAcFun{parallel: 2}, err: true},
+		{name: "fragment refused", f: &fakeAcFun{partSize: 1001, parallel: 2, fail: func(part int64, attempt int) bool { return part == 2 }}, err: true},
+	}
+	setFlag(t, "retry-attempts", "1")
+	dir := t.TempDir()
+	v := filepath.Join(dir, "video.mp4")
+	if err := ioutil.WriteFile(v, testContent(5000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer(t, tt.f)
+			before := openFiles(t, dir)
+			_, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if after := openFiles(t, dir); after != before {
+				t.Errorf("%d files open in %s after the upload, %d before", after, dir, before)
+			}
+		})
+	}
+}
+
+func TestUploadEmptyFile(t *testing.T) {
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request to %s for an empty file", r.URL.Path)
+		http.NotFound(w, r)
+	}))
+	v := filepath.Join(t.TempDir(), "empty.mp4")
+	if err := ioutil.WriteFile(v, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("uploadFile: got %v, want an error about the empty file", err)
+	}
+	if _, err := uploadReader(context.Background(), "empty.mp4", bytes.NewReader(nil), 0, nil, "", &VideoMeta{}, nil); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("uploadReader: got %v, want an error about the empty input", err)
+	}
+}
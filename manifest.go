@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var manifestColumns = []string{"path", "hash", "size", "video_id", "url", "title", "channel", "time"}
+
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	VideoID int64     `json:"video_id"`
+	URL     string    `json:"url,omitempty"`
+	Title   string    `json:"title"`
+	Channel int       `json:"channel,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Manifest records every uploaded file, one entry per content hash, or
+// per hash and range for -clip uploads (see clipKey). It is kept across
+// runs, so files already in it are not uploaded again.
+// Files ending in .csv are written as CSV, anything else as JSON.
+type Manifest struct {
+	path    string
+	Entries []*ManifestEntry
+}
+
+func loadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if m.isCSV() {
+		err = m.readCSV(file)
+	} else {
+		err = json.NewDecoder(file).Decode(&m.Entries)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading manifest %s: %v", path, err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) isCSV() bool {
+	return strings.EqualFold(filepath.Ext(m.path), ".csv")
+}
+
+func (m *Manifest) readCSV(r io.Reader) error {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, rec := range records {
+		if i == 0 && len(rec) > 0 && rec[0] == manifestColumns[0] {
+			continue
+		}
+		if len(rec) != len(manifestColumns) {
+			return fmt.Errorf("line %d: expected %d columns, got %d", i+1, len(manifestColumns), len(rec))
+		}
+		e := &ManifestEntry{Path: rec[0], Hash: rec[1], URL: rec[4], Title: rec[5]}
+		e.Size, _ = strconv.ParseInt(rec[2], 10, 64)
+		e.VideoID, _ = strconv.ParseInt(rec[3], 10, 64)
+		e.Channel, _ = strconv.Atoi(rec[6])
+		e.Time, _ = time.Parse(time.RFC3339, rec[7])
+		m.Entries = append(m.Entries, e)
+	}
+	return nil
+}
+
+func (m *Manifest) Lookup(hash string) *ManifestEntry {
+	for _, e := range m.Entries {
+		if e.Hash == hash {
+			return e
+		}
+	}
+	return nil
+}
+
+// Add stores e, replacing any previous entry with the same hash.
+func (m *Manifest) Add(e *ManifestEntry) {
+	for i, old := range m.Entries {
+		if old.Hash == e.Hash {
+			m.Entries[i] = e
+			return
+		}
+	}
+	m.Entries = append(m.Entries, e)
+}
+
+// Save writes the manifest through a temp file, so an interrupted run
+// never leaves a truncated manifest behind.
+func (m *Manifest) Save() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), ".manifest-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if m.isCSV() {
+		w := csv.NewWriter(tmp)
+		_ = w.Write(manifestColumns)
+		for _, e := range m.Entries {
+			_ = w.Write([]string{
+				e.Path, e.Hash, strconv.FormatInt(e.Size, 10), strconv.FormatInt(e.VideoID, 10),
+				e.URL, e.Title, strconv.Itoa(e.Channel), e.Time.Format(time.RFC3339),
+			})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		enc := json.NewEncoder(tmp)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(m.Entries)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+func manifestEntry(file, hash string, up *Upload) *ManifestEntry {
+	e := &ManifestEntry{
+		Path:    file,
+		Hash:    hash,
+		VideoID: up.VideoID,
+		Title:   up.Meta.Title,
+		Channel: up.Meta.Channel,
+		Time:    time.Now(),
+	}
+	if info, err := os.Stat(file); err == nil {
+		e.Size = info.Size()
+	}
+	if up.DougaID != 0 {
+		e.URL = dougaURL(up.DougaID)
+	}
+	return e
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return formatHash(h), nil
+}
+
+// newHash returns the -checksum-algo hash of whole files.
+func newHash() hash.Hash {
+	if *checksumAlgo == "sha256" {
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// formatHash prefixes the hashes other than md5 with their algorithm, md5
+// hashes stay bare to match the manifests written before.
+func formatHash(h hash.Hash) string {
+	sum := hex.EncodeToString(h.Sum(nil))
+	if *checksumAlgo == "sha256" {
+		return "sha256:" + sum
+	}
+	return sum
+}
+
+// hashAlgo returns the algorithm of a hash made by formatHash.
+func hashAlgo(hash string) string {
+	if strings.HasPrefix(hash, "sha256:") {
+		return "sha256"
+	}
+	return "md5"
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// stripMetadata remuxes file into a temp dir without its metadata tags
+// (title, comment, creation device, GPS location...) and chapters. Only
+// the video and audio streams are kept, data streams may carry location
+// tracks too. The streams are copied, never re-encoded, and the file name
+// stays the same. The caller removes the returned dir.
+func stripMetadata(ctx context.Context, file string) (dir, stripped string, err error) {
+	dir, err = tempDir("acfun-strip-")
+	if err != nil {
+		return "", "", err
+	}
+	stripped = filepath.Join(dir, filepath.Base(file))
+	_, err = runTool(ctx, "ffmpeg", "-v", "error", "-i", file,
+		"-map", "0:v", "-map", "0:a?", "-c", "copy",
+		"-map_metadata", "-1", "-map_chapters", "-1",
+		// keeps ffmpeg from writing its own encoder tag
+		"-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact",
+		"-y", stripped)
+	if err != nil {
+		return dir, "", err
+	}
+	return dir, stripped, nil
+}
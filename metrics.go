@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeMetrics writes the batch results in the Prometheus textfile
+// collector format. The file is replaced atomically, as node_exporter may
+// read it at any time.
+func writeMetrics(path string, b *Batch) error {
+	uploaded, skipped, failed := b.Count()
+	var bytes, retries int64
+	for _, r := range b.Results {
+		retries += r.Retries
+		if r.Err == nil && !r.Skipped {
+			bytes += r.Size
+		}
+	}
+
+	w := new(strings.Builder)
+	metric := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	metric("acfun_uploader_files", "Files of the last run by result.")
+	fmt.Fprintf(w, "acfun_uploader_files{result=\"uploaded\"} %d\n", uploaded)
+	fmt.Fprintf(w, "acfun_uploader_files{result=\"skipped\"} %d\n", skipped)
+	fmt.Fprintf(w, "acfun_uploader_files{result=\"failed\"} %d\n", failed)
+	metric("acfun_uploader_failures", "Files that failed in the last run.")
+	fmt.Fprintf(w, "acfun_uploader_failures %d\n", failed)
+	metric("acfun_uploader_bytes", "Bytes of the files uploaded in the last run.")
+	fmt.Fprintf(w, "acfun_uploader_bytes %d\n", bytes)
+	metric("acfun_uploader_retries", "Failed fragment attempts in the last run.")
+	fmt.Fprintf(w, "acfun_uploader_retries %d\n", retries)
+	metric("acfun_uploader_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(w, "acfun_uploader_duration_seconds %.3f\n", time.Since(b.Started).Seconds())
+	metric("acfun_uploader_last_run_timestamp_seconds", "Time the last run finished.")
+	fmt.Fprintf(w, "acfun_uploader_last_run_timestamp_seconds %d\n", time.Now().Unix())
+	metric("acfun_uploader_file_speed_bytes_per_second", "Average upload speed of each uploaded file.")
+	for _, r := range b.Results {
+		if r.Err == nil && !r.Skipped && r.Duration > 0 {
+			fmt.Fprintf(w, "acfun_uploader_file_speed_bytes_per_second{file=\"%s\"} %.0f\n",
+				escapeLabel(r.File), float64(r.Size)/r.Duration.Seconds())
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".metrics-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(w.String()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates 0600, node_exporter usually runs as another user
+	_ = os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), path)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
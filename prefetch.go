@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// Prefetch fetches the upload config of the next file in the batch while
+// the current one is still transferring. Only one file ahead is fetched
+// so the token does not sit around long enough to expire.
+type Prefetch struct {
+	file   string
+	size   int64
+	config *UploadConfigResp
+	err    error
+	done   chan struct{}
+}
+
+// fileCheck holds what decides whether a file of the batch is uploaded at
+// all: a refusal by the size or duration checks, or the manifest entry of
+// an earlier upload. Warnings are printed when the file's turn comes.
+// sum is the hash of the whole file, hash the manifest key made of it.
+type fileCheck struct {
+	err      error
+	warnings []string
+	sum      string
+	hash     string
+	entry    *ManifestEntry
+}
+
+// prefetchable reports whether the checked file v is going to be uploaded
+// with a new upload config. A config prefetched for a file that is then
+// skipped, or that continues a saved upload, would leave an upload task
+// on the server that is never used.
+func (c *fileCheck) prefetchable(v string) bool {
+	if c.err != nil || c.entry != nil {
+		return false
+	}
+	if *resume {
+		info, err := getFileInfo(v)
+		if err != nil || loadResumeState(v, info) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func prefetchConfig(ctx context.Context, file string) *Prefetch {
+	p := &Prefetch{file: file, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		var info os.FileInfo
+		info, p.err = getFileInfo(file)
+		if p.err != nil {
+			return
+		}
+		p.size = info.Size()
+		p.config, p.err = api.UploadConfig(ctx, info.Name(), p.size)
+	}()
+	return p
+}
+
+// Config waits for the prefetch to finish. It returns nil when the
+// prefetch failed or the file no longer has the size the config was
+// asked for, the caller then fetches the config itself.
+func (p *Prefetch) Config(ctx context.Context, size int64) *UploadConfigResp {
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return nil
+	}
+	if p.err != nil {
+		if *debug {
+			log.Printf("prefetching upload config of %s failed: %v", p.file, p.err)
+		}
+		return nil
+	}
+	if p.size != size {
+		if *debug {
+			log.Printf("%s changed from %d to %d bytes since its upload config was prefetched", p.file, p.size, size)
+		}
+		return nil
+	}
+	if *debug {
+		log.Printf("using prefetched upload config of %s", p.file)
+	}
+	return p.config
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// The profiling flags are left out of the usage text, they are meant for
+// looking into slow or memory-hungry uploads with go tool pprof.
+var (
+	cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfile = flag.String("memprofile", "", "Write a heap profile to this file when the run ends")
+)
+
+var hiddenFlags = map[string]bool{
+	"cpuprofile": true,
+	"memprofile": true,
+}
+
+// startProfiles starts the profiles asked for, the returned function
+// stops them and writes them out.
+func startProfiles() (func(), error) {
+	var cpu *os.File
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpu = f
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if *memProfile != "" {
+			if err := writeHeapProfile(*memProfile); err != nil {
+				fmt.Fprintf(os.Stderr, tr("writeHeapProfile returns error: %v\n"), err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printDefaults is flag.PrintDefaults without the hidden flags.
+func printDefaults() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		fs.Var(f.Value, f.Name, tr(f.Usage))
+		fs.Lookup(f.Name).DefValue = f.DefValue
+	})
+	fs.PrintDefaults()
+}
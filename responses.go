@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// saveResponse keeps the raw body of a publish step in -save-response,
+// named after the task so the responses of one upload sort together.
+// Failing to save only gets logged, the upload itself went through.
+func saveResponse(task, step string, body []byte) {
+	if *saveResp == "" {
+		return
+	}
+	if err := os.MkdirAll(*saveResp, 0700); err != nil {
+		log.Printf("saving %s response returns error: %v", step, err)
+		return
+	}
+	file := filepath.Join(*saveResp, task+"-"+step+".json")
+	if err := ioutil.WriteFile(file, body, 0600); err != nil {
+		log.Printf("saving %s response returns error: %v", step, err)
+	}
+}
+
+// warnResponse logs the message a successful response came with, it is
+// the only place the API puts warnings.
+func warnResponse(step, errorMsg string) {
+	if errorMsg != "" {
+		log.Printf("%s succeeded with message: %s", step, errorMsg)
+	}
+}
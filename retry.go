@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	controlRetries = 3
+	retryDelay     = time.Second
+	retryMaxDelay  = 30 * time.Second
+	// retryJitterDefault spreads the retries of fragments that failed
+	// together, so they don't all hit the server again at the same time.
+	retryJitterDefault = 0.2
+)
+
+var (
+	retryAttempts = flag.Int("retry-attempts", 0, "Attempts per request or fragment before giving up "+
+		"(0 means 3 for API calls and the server's retryCount, or no limit, for fragments)")
+	retryBase   = flag.Duration("retry-delay", 0, "Delay before the first retry, doubled after every failure (0 means 1s or the server's retryDurationSeconds)")
+	retryMax    = flag.Duration("retry-max-delay", retryMaxDelay, "Longest delay between two retries")
+	retryJitter = flag.Float64("retry-jitter", retryJitterDefault, "Randomize every retry delay by up to this fraction (0 to 1, 0 turns it off)")
+	retryBudget = flag.Int("retry-budget", 0, "Fail a file once its fragments were retried this many times in total (0 means no limit)")
+)
+
+func init() {
+	// without a seed every run, and every process started together,
+	// would draw the same delays
+	rand.Seed(time.Now().UnixNano())
+}
+
+// RetryPolicy decides how often and how long apart a failed request is
+// tried again. Attempts of 0 means no limit.
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+	Jitter   float64
+}
+
+// retryPolicy returns the policy for requests whose own defaults are
+// attempts and delay, -retry-* flags replace them when set.
+func retryPolicy(attempts int, delay time.Duration) *RetryPolicy {
+	p := &RetryPolicy{Attempts: attempts, Delay: delay, MaxDelay: *retryMax, Jitter: *retryJitter}
+	if *retryAttempts > 0 {
+		p.Attempts = *retryAttempts
+	}
+	if *retryBase > 0 {
+		p.Delay = *retryBase
+	}
+	if p.Delay <= 0 {
+		p.Delay = retryDelay
+	}
+	return p
+}
+
+// Exhausted reports whether no attempt is left after the failed-th one.
+func (p *RetryPolicy) Exhausted(failed int) bool {
+	return p.Attempts > 0 && failed >= p.Attempts
+}
+
+// Wait returns the delay after the failed-th failure.
+func (p *RetryPolicy) Wait(failed int) time.Duration {
+	d := p.Delay
+	for i := 1; i < failed && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.Jitter*(rand.Float64()*2-1)))
+	}
+	return d
+}
+
+// retry calls fn until it succeeds or the policy gives up, backing off
+// after every failure. Each failure is logged under name so that retries
+// of different kinds of requests can be told apart.
+func retry(ctx context.Context, name string, p *RetryPolicy, fn func() error) error {
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || p.Exhausted(i) || !retryable(err) {
+			return err
+		}
+		delay := p.Wait(i)
+		attempt := strconv.Itoa(i)
+		if p.Attempts > 0 {
+			attempt += "/" + strconv.Itoa(p.Attempts)
+		}
+		log.Printf("%s: attempt %s failed: %v (retrying in %v)", name, attempt, err, delay.Round(time.Millisecond))
+		sleep(ctx, delay)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRetryWaitJitter(t *testing.T) {
+	rand.Seed(1)
+	p := &RetryPolicy{Delay: time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+	for failed, base := range map[int]time.Duration{1: time.Second, 3: 4 * time.Second} {
+		lo, hi := base*8/10, base*12/10
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			d := p.Wait(failed)
+			if d < lo || d > hi {
+				t.Fatalf("Wait(%d) = %v, want %v to %v", failed, d, lo, hi)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 50 {
+			t.Errorf("Wait(%d) drew only %d different delays in 100 calls", failed, len(seen))
+		}
+	}
+}
+
+func TestRetryPolicyFlags(t *testing.T) {
+	tests := []struct {
+		flagAttempts string
+		flagDelay    string
+		attempts     int
+		delay        time.Duration
+		wantAttempts int
+		wantDelay    time.Duration
+	}{
+		{"0", "0s", 3, 0, 3, retryDelay},
+		{"0", "0s", 0, 5 * time.Second, 0, 5 * time.Second},
+		{"-1", "-1s", 3, 2 * time.Second, 3, 2 * time.Second},
+		{"-1", "-1s", 0, -time.Second, 0, retryDelay},
+		{"5", "3s", 3, 2 * time.Second, 5, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		setFlag(t, "retry-attempts", tt.flagAttempts)
+		setFlag(t, "retry-delay", tt.flagDelay)
+		p := retryPolicy(tt.attempts, tt.delay)
+		if p.Attempts != tt.wantAttempts || p.Delay != tt.wantDelay {
+			t.Errorf("-retry-attempts %s -retry-delay %s, retryPolicy(%d, %v) = %d attempts, delay %v, want %d, %v",
+				tt.flagAttempts, tt.flagDelay, tt.attempts, tt.delay, p.Attempts, p.Delay, tt.wantAttempts, tt.wantDelay)
+		}
+	}
+}
+
+func TestRetryWaitWithoutJitter(t *testing.T) {
+	p := &RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second}
+	for failed, want := range map[int]time.Duration{0: time.Second, 1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := p.Wait(failed); got != want {
+			t.Errorf("Wait(%d) = %v, want %v", failed, got, want)
+		}
+	}
+	for _, attempts := range []int{0, -1} {
+		p := &RetryPolicy{Attempts: attempts}
+		if p.Exhausted(100) {
+			t.Errorf("a policy with %d attempts gave up", attempts)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	maxSize = flag.String("max-size", "", "Refuse files larger than this, e.g. 4GiB or 500MB, unless -force is given")
+	force   = flag.Bool("force", false, "Upload files over -max-size anyway, or overwrite an existing config file with -config-init")
+)
+
+// sizeUnits maps the suffixes accepted by -max-size to bytes, longest
+// first so that "MiB" is not taken for "B".
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"kB", 1e3}, {"B", 1},
+}
+
+// parseSize parses values like "4GiB" or "500MB" into bytes, a bare
+// number is bytes.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid -max-size %q, use a size like 4GiB or 500MB", *maxSize)
+	}
+	return int64(v * factor), nil
+}
+
+// formatSize prints n bytes in the largest binary unit below it.
+func formatSize(n int64) string {
+	for _, u := range sizeUnits[:4] {
+		if float64(n) >= u.factor {
+			return fmt.Sprintf("%.2f %s", float64(n)/u.factor, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// checkSize refuses file when it is over -max-size, already validated by
+// checkFlags.
+func checkSize(file string) error {
+	if *maxSize == "" || *force {
+		return nil
+	}
+	limit, _ := parseSize(*maxSize)
+	info, err := os.Stat(file)
+	if err != nil {
+		// left for the upload to report
+		return nil
+	}
+	if info.Size() > limit {
+		return fmt.Errorf("skipped: %s is %s, over -max-size %s (pass -force to upload it anyway)",
+			file, formatSize(info.Size()), formatSize(limit))
+	}
+	return nil
+}
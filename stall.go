@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// stallReader is a fragment body that remembers when it was last read
+// from, so that a fragment whose upload stopped moving can be told apart
+// from one that is merely slow.
+type stallReader struct {
+	r    *bytes.Reader
+	last int64 // unix nanoseconds of the last read
+	done int32
+}
+
+func newStallReader(content []byte) *stallReader {
+	return &stallReader{r: bytes.NewReader(content), last: time.Now().UnixNano()}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+	if err != nil {
+		atomic.StoreInt32(&s.done, 1)
+	}
+	return n, err
+}
+
+// watchStall cancels the request once body was not read from for window
+// while there was still data left to send. Waiting for the response
+// after the whole body went out is left to the client timeout. The
+// returned function stops the watch and reports whether it fired.
+func watchStall(body *stallReader, window time.Duration, cancel context.CancelFunc) func() bool {
+	if window <= 0 {
+		return func() bool { return false }
+	}
+	var stalled int32
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(window / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			if atomic.LoadInt32(&body.done) == 1 {
+				return
+			}
+			if time.Since(time.Unix(0, atomic.LoadInt64(&body.last))) > window {
+				atomic.StoreInt32(&stalled, 1)
+				cancel()
+				return
+			}
+		}
+	}()
+	return func() bool {
+		close(stop)
+		return atomic.LoadInt32(&stalled) == 1
+	}
+}
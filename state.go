@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateDir is where resume states and other data kept between runs live.
+func stateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "acfun-uploader")
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// ResumeState records an unfinished upload, so that a later run with
+// -resume can continue with the same token and skip the fragments the
+// server already confirmed. Fragments is the authoritative fragment count
+// of the whole file, the complete call always reports it no matter how
+// many sessions the upload took.
+//
+// The state is self-contained, Hash lets it be carried to another machine
+// together with the file, see importResumeState.
+type ResumeState struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	Token     string    `json:"token"`
+	TaskID    string    `json:"task_id"`
+	PartSize  int       `json:"part_size"`
+	Parallel  int       `json:"parallel"`
+	Fragments int64     `json:"fragments"`
+	Done      []int64   `json:"done"`
+	Created   time.Time `json:"created"`
+	Hash      string    `json:"hash"`
+
+	mu   sync.Mutex
+	file string
+	done map[int64]bool
+}
+
+func resumeStateFile(path string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(abs))
+	return filepath.Join(dir, "resume-"+hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// newResumeState returns the state of a new upload of path. sum is the
+// hash of path if the caller has it already, the file is read for it
+// otherwise.
+func newResumeState(path string, info os.FileInfo, config *UploadConfigResp, sum string) (*ResumeState, error) {
+	file, err := resumeStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, _ := filepath.Abs(path)
+	if sum == "" {
+		if sum, err = hashFile(path); err != nil {
+			return nil, err
+		}
+	}
+	partSize := int64(config.Config.PartSize - 1)
+	return &ResumeState{
+		Path:      abs,
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Token:     config.Token,
+		TaskID:    config.TaskID,
+		PartSize:  config.Config.PartSize,
+		Parallel:  config.Config.Parallel,
+		Fragments: (info.Size() + partSize - 1) / partSize,
+		Created:   time.Now(),
+		Hash:      sum,
+		file:      file,
+		done:      make(map[int64]bool),
+	}, nil
+}
+
+// loadResumeState returns the saved state of path, or nil when there is
+// none or the file changed since.
+func loadResumeState(path string, info os.FileInfo) *ResumeState {
+	file, err := resumeStateFile(path)
+	if err != nil {
+		return nil
+	}
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	s := new(ResumeState)
+	if err := json.Unmarshal(body, s); err != nil {
+		log.Printf("ignoring broken resume state %s: %v", file, err)
+		return nil
+	}
+	if s.PartSize <= 1 || s.PartSize > maxPartSize || s.Parallel < 1 {
+		log.Printf("ignoring broken resume state %s: part size %d, parallel %d", file, s.PartSize, s.Parallel)
+		return nil
+	}
+	if s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) {
+		log.Printf("%s changed since the interrupted upload, starting over", path)
+		_ = os.Remove(file)
+		return nil
+	}
+	s.file = file
+	s.done = make(map[int64]bool)
+	for _, part := range s.Done {
+		s.done[part] = true
+	}
+	return s
+}
+
+// importResumeState reads a state saved for path on another machine from
+// file. The local copy must have the same size and hash, it is then saved
+// as the state of path here. sum is the hash of path, see newResumeState.
+func importResumeState(file, path string, info os.FileInfo, sum string) (*ResumeState, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := new(ResumeState)
+	if err := json.Unmarshal(body, s); err != nil {
+		return nil, fmt.Errorf("%s is not a resume state: %v", file, err)
+	}
+	switch {
+	case s.Token == "" || s.Fragments == 0:
+		return nil, fmt.Errorf("%s is not a resume state", file)
+	case s.PartSize <= 1 || s.PartSize > maxPartSize || s.Parallel < 1:
+		return nil, fmt.Errorf("%s is broken: part size %d, parallel %d", file, s.PartSize, s.Parallel)
+	case s.Hash == "":
+		return nil, fmt.Errorf("%s has no file hash, it was saved by an older version and can't be moved", file)
+	case strings.HasPrefix(s.Hash, "sha256:") != (*checksumAlgo == "sha256"):
+		return nil, fmt.Errorf("%s was saved with another -checksum-algo, use the same one on both machines", file)
+	case s.Size != info.Size():
+		return nil, fmt.Errorf("%s is %d bytes, the upload in %s is of %d bytes", path, info.Size(), file, s.Size)
+	}
+	if sum == "" {
+		if sum, err = hashFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if sum != s.Hash {
+		return nil, fmt.Errorf("%s is not the file whose upload is in %s, the hashes differ", path, file)
+	}
+	if s.file, err = resumeStateFile(path); err != nil {
+		return nil, err
+	}
+	s.Path, _ = filepath.Abs(path)
+	s.ModTime = info.ModTime()
+	s.done = make(map[int64]bool)
+	for _, part := range s.Done {
+		s.done[part] = true
+	}
+	return s, s.Save()
+}
+
+func (s *ResumeState) UploadConfig() *UploadConfigResp {
+	return &UploadConfigResp{
+		TaskID: s.TaskID,
+		Token:  s.Token,
+		Config: UploadConfigBlock{
+			PartSize: s.PartSize,
+			Parallel: s.Parallel,
+		},
+	}
+}
+
+func (s *ResumeState) Confirmed(part int64) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done[part]
+}
+
+// ConfirmedBytes returns how many bytes of the file the confirmed
+// fragments cover, the last fragment may be shorter than partSize.
+func (s *ResumeState) ConfirmedBytes(partSize int64) int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for part := range s.done {
+		offset := part * partSize
+		if offset >= s.Size {
+			continue
+		}
+		if s.Size-offset < partSize {
+			n += s.Size - offset
+		} else {
+			n += partSize
+		}
+	}
+	return n
+}
+
+// Confirm marks part as uploaded and saves the state right away, so a
+// crash loses at most the fragments in flight.
+func (s *ResumeState) Confirm(part int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done[part] = true
+	if err := s.save(); err != nil {
+		log.Printf("saving resume state returns error: %v", err)
+	}
+}
+
+// Sync replaces the confirmed fragments with the ones the server reports
+// having, which is what the complete call will be judged by.
+func (s *ResumeState) Sync(server []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	done := make(map[int64]bool)
+	for _, part := range server {
+		if part >= 0 && part < s.Fragments {
+			done[part] = true
+		}
+	}
+	lost, found := 0, 0
+	for part := range s.done {
+		if !done[part] {
+			lost++
+		}
+	}
+	for part := range done {
+		if !s.done[part] {
+			found++
+		}
+	}
+	if lost > 0 || found > 0 {
+		log.Printf("the server has %d fragment(s) not saved as confirmed and misses %d saved ones, following the server", found, lost)
+	}
+	s.done = done
+	if err := s.save(); err != nil {
+		log.Printf("saving resume state returns error: %v", err)
+	}
+}
+
+func (s *ResumeState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *ResumeState) save() error {
+	s.Done = s.Done[:0]
+	for part := range s.done {
+		s.Done = append(s.Done, part)
+	}
+	sort.Slice(s.Done, func(i, j int) bool {
+		return s.Done[i] < s.Done[j]
+	})
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *ResumeState) Remove() {
+	if s == nil {
+		return
+	}
+	_ = os.Remove(s.file)
+}
+
+// resumeTokenTTL is how long an upload token is assumed to stay valid.
+// The server does not say, older states usually fall back to a new token.
+const resumeTokenTTL = 24 * time.Hour
+
+// listResumeStates returns every saved resume state, oldest first.
+func listResumeStates() ([]*ResumeState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "resume-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []*ResumeState
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s := &ResumeState{file: file}
+		if err := json.Unmarshal(body, s); err != nil {
+			log.Printf("ignoring broken resume state %s: %v", file, err)
+			continue
+		}
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Created.Before(states[j].Created)
+	})
+	return states, nil
+}
+
+// Stale tells why the state can't be resumed any more, or "" if it can.
+func (s *ResumeState) Stale() string {
+	info, err := os.Stat(s.Path)
+	switch {
+	case err != nil:
+		return "source file is gone"
+	case info.Size() != s.Size || !info.ModTime().Equal(s.ModTime):
+		return "source file changed"
+	case time.Since(s.Created) > resumeTokenTTL:
+		return "token likely expired"
+	}
+	return ""
+}
+
+func printResumeStates(states []*ResumeState, clean bool) error {
+	if len(states) == 0 {
+		fmt.Fprint(msg, tr("No resumable uploads\n"))
+		return nil
+	}
+	for _, s := range states {
+		progress := 0.0
+		if s.Fragments > 0 {
+			progress = float64(len(s.Done)) / float64(s.Fragments) * 100
+		}
+		status := "resumable"
+		if stale := s.Stale(); stale != "" {
+			status = stale
+		}
+		fmt.Fprintf(msg, tr("%s\t%5.1f%%\t%v old\t%s\t%s\n"), s.Path, progress, time.Since(s.Created).Round(time.Minute), tr(status), s.file)
+		if clean && status != "resumable" {
+			if err := os.Remove(s.file); err != nil {
+				return err
+			}
+			fmt.Fprint(msg, tr("  removed\n"))
+		}
+	}
+	return nil
+}
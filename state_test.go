@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// resumeTestFile writes a file of 7 fragments for a part size of 1025,
+// the last one short.
+func resumeTestFile(t *testing.T) (string, os.FileInfo) {
+	t.Helper()
+	v := filepath.Join(t.TempDir(), "video.mp4")
+	if err := ioutil.WriteFile(v, testContent(6*1024+100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, info
+}
+
+// An upload that dies half way is continued by the next run with the
+// fragments it misses, and completed with the fragment count of the
+// whole file, the same one an upload in one go reports.
+func TestResumeAfterCrash(t *testing.T) {
+	useStateDir(t)
+	setFlag(t, "retry-attempts", "1")
+	v, info := resumeTestFile(t)
+
+	whole := &fakeAcFun{partSize: 1025, parallel: 1}
+	testServer(t, whole)
+	if _, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := whole.Completes(); !reflect.DeepEqual(got, []string{"7"}) {
+		t.Fatalf("upload in one go completed with %v fragments, want [7]", got)
+	}
+
+	setFlag(t, "resume", "true")
+	crashed := &fakeAcFun{partSize: 1025, parallel: 1, fail: func(part int64, attempt int) bool { return part >= 3 }}
+	testServer(t, crashed)
+	if _, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil); err == nil {
+		t.Fatal("the crashed upload returned no error")
+	}
+	if got := crashed.Completes(); len(got) > 0 {
+		t.Fatalf("the crashed upload was completed with %v fragments", got)
+	}
+	state := loadResumeState(v, info)
+	if state == nil {
+		t.Fatal("no resume state saved by the crashed upload")
+	}
+	if want := []int64{0, 1, 2}; !reflect.DeepEqual(state.Done, want) {
+		t.Fatalf("saved fragments %v, want %v", state.Done, want)
+	}
+
+	resumed := &fakeAcFun{partSize: 1025, parallel: 1}
+	testServer(t, resumed)
+	if _, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resumed.Fragments(), []int64{3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed upload sent fragments %v, want %v", got, want)
+	}
+	if got, want := resumed.Completes(), whole.Completes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed upload completed with %v fragments, want %v like the upload in one go", got, want)
+	}
+	if loadResumeState(v, info) != nil {
+		t.Error("resume state kept after the upload was finished")
+	}
+}
+
+// The fragment list of the resume response wins over the saved state,
+// both for fragments the server has beyond it and for ones it lost.
+func TestResumeFollowsServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		saved  []int64
+		server string
+		sent   []int64
+	}{
+		{name: "server has more", saved: []int64{0, 1}, server: "[0,1,2,5]", sent: []int64{3, 4, 6}},
+		{name: "server lost some", saved: []int64{0, 1, 2}, server: "[0,2]", sent: []int64{1, 3, 4, 5, 6}},
+		{name: "no fragment list", saved: []int64{0, 1, 2}, sent: []int64{3, 4, 5, 6}},
+	}
+	setFlag(t, "resume", "true")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			v, info := resumeTestFile(t)
+			config := &UploadConfigResp{TaskID: "task", Token: "tok", Config: UploadConfigBlock{PartSize: 1025, Parallel: 1}}
+			saved, err := newResumeState(v, info, config, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, part := range tt.saved {
+				saved.done[part] = true
+			}
+			if err := saved.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			f := &fakeAcFun{partSize: 1025, parallel: 1, resume: tt.server}
+			testServer(t, f)
+			if _, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Fragments(); !reflect.DeepEqual(got, tt.sent) {
+				t.Errorf("sent fragments %v, want %v", got, tt.sent)
+			}
+			if got := f.Completes(); !reflect.DeepEqual(got, []string{"7"}) {
+				t.Errorf("completed with %v fragments, want [7]", got)
+			}
+		})
+	}
+}
+
+// The hash checkFile already computed is saved in the state instead of
+// reading the file once more, and reported for the resumed upload, whose
+// fragments of the first session were never streamed through the hash.
+func TestResumeStateGivenHash(t *testing.T) {
+	useStateDir(t)
+	setFlag(t, "resume", "true")
+	setFlag(t, "retry-attempts", "1")
+	v, info := resumeTestFile(t)
+	const sum = "given"
+
+	testServer(t, &fakeAcFun{partSize: 1025, parallel: 1, fail: func(part int64, attempt int) bool { return part >= 3 }})
+	if _, err := uploadFile(context.Background(), v, sum, &VideoMeta{}, nil); err == nil {
+		t.Fatal("the crashed upload returned no error")
+	}
+	state := loadResumeState(v, info)
+	if state == nil {
+		t.Fatal("no resume state saved by the crashed upload")
+	}
+	if state.Hash != sum {
+		t.Errorf("state saved with hash %q, want the given %q", state.Hash, sum)
+	}
+
+	testServer(t, &fakeAcFun{partSize: 1025, parallel: 1})
+	up, err := uploadFile(context.Background(), v, "", &VideoMeta{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Hash != sum {
+		t.Errorf("resumed upload has hash %q, want %q from the state", up.Hash, sum)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTags      = 6
+	maxTagLength = 20
+)
+
+// normalizeTags splits the -tags input on commas (ASCII, full-width and
+// 、), or on white space when there is no comma at all. Tags are trimmed,
+// empty ones dropped and duplicates removed ignoring case, keeping the
+// first spelling.
+func normalizeTags(input string) ([]string, error) {
+	isComma := func(r rune) bool {
+		return r == ',' || r == '，' || r == '、'
+	}
+	var fields []string
+	if strings.IndexFunc(input, isComma) >= 0 {
+		fields = strings.FieldsFunc(input, isComma)
+	} else {
+		fields = strings.FieldsFunc(input, unicode.IsSpace)
+	}
+
+	var tags, rejected []string
+	seen := make(map[string]bool)
+	for _, tag := range fields {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			rejected = append(rejected, tag)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("tags longer than %d characters: %s", maxTagLength, strings.Join(rejected, ", "))
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed, got %d, rejected: %s",
+			maxTags, len(tags), strings.Join(tags[maxTags:], ", "))
+	}
+	return tags, nil
+}
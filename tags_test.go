@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		err   bool
+	}{
+		{input: "", want: nil},
+		{input: " , ,", want: nil},
+		{input: "gaming, 游戏,  gaming", want: []string{"gaming", "游戏"}},
+		{input: "Gaming,gaming,GAMING", want: []string{"Gaming"}},
+		{input: "  a  ,\tb ", want: []string{"a", "b"}},
+		{input: "a b  c", want: []string{"a", "b", "c"}},
+		{input: "a b, c d", want: []string{"a b", "c d"}},
+		{input: "动画，游戏、音乐", want: []string{"动画", "游戏", "音乐"}},
+		{input: "1,2,3,4,5,6", want: []string{"1", "2", "3", "4", "5", "6"}},
+		{input: "1,2,3,4,5,6,1,2", want: []string{"1", "2", "3", "4", "5", "6"}},
+		{input: "1,2,3,4,5,6,7", err: true},
+		{input: strings.Repeat("长", maxTagLength), want: []string{strings.Repeat("长", maxTagLength)}},
+		{input: "ok," + strings.Repeat("长", maxTagLength+1), err: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeTags(tt.input)
+		if tt.err {
+			if err == nil {
+				t.Errorf("normalizeTags(%q) = %q, want an error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("normalizeTags(%q) returns error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTagsRejected(t *testing.T) {
+	long := strings.Repeat("x", maxTagLength+1)
+	_, err := normalizeTags("a," + long)
+	if err == nil || !strings.Contains(err.Error(), long) {
+		t.Errorf("got %v, want an error naming %s", err, long)
+	}
+	_, err = normalizeTags("1,2,3,4,5,6,7,8")
+	if err == nil || !strings.Contains(err.Error(), "7, 8") {
+		t.Errorf("got %v, want an error naming 7, 8", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// -tail is experimental: the fragment endpoint has only been seen with
+// the final file size in Content-Range. While the file grows the total is
+// sent as "*", which HTTP allows for an unknown length, but whether the
+// server accepts that is not known.
+var (
+	tail      = flag.Bool("tail", false, "Experimental: upload a file that is still being recorded as it grows, finishing once it stopped growing for -tail-quiet")
+	tailQuiet = flag.Duration("tail-quiet", 30*time.Second, "With -tail, how long the file must stop growing before it is taken as complete")
+)
+
+const (
+	// tailPoll is how often a growing file is checked for new data.
+	tailPoll = time.Second
+	// tailReads is how often a short read of a growing file is tried.
+	tailReads = 5
+)
+
+func checkTail() error {
+	if !*tail {
+		return nil
+	}
+	if *tailQuiet <= 0 {
+		return errors.New(tr("-tail-quiet must be positive"))
+	}
+	if *resume || *clip != "" || *stripMeta || *dryUpload || *noFinish || *uploadToken != "" || *autoParallel || *manifestTo != "" {
+		return errors.New(tr("-tail can't be used with -resume, -clip, -strip-metadata, -dry-upload, -no-finish, -upload-token, -concurrency-auto or -manifest-output"))
+	}
+	return nil
+}
+
+// tailRead reads length bytes at offset of file. The size the recorder
+// reported may be ahead of the data that can be read yet, a short read is
+// tried again after tailPoll up to tailReads times before giving up.
+func tailRead(ctx context.Context, file io.ReaderAt, offset int64, length int) ([]byte, error) {
+	buf := make([]byte, length)
+	for i := 1; ; i++ {
+		n, err := file.ReadAt(buf, offset)
+		if n == length {
+			return buf, nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if i == tailReads {
+			return nil, fmt.Errorf("only %d of %d bytes could be read", n, length)
+		}
+		if *debug {
+			log.Printf("short read of %d of %d bytes at offset %d, trying again", n, length, offset)
+		}
+		sleep(ctx, tailPoll)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tailUpload uploads v one fragment at a time as soon as the recording
+// wrote a whole fragment. When the size stayed the same for -tail-quiet
+// the rest is sent with the final size and the upload is finished.
+func tailUpload(parent context.Context, v string, meta *VideoMeta) (*Upload, error) {
+	ctx := parent
+	if *fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, *fileTimeout)
+		defer cancel()
+	}
+	file, err := os.Open(v)
+	if err != nil {
+		return nil, fmt.Errorf("open returns error: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat returns error: %w", err)
+	}
+
+	// the final size is not known yet, the server gets the current one
+	config, err := fetchUploadConfig(ctx, filepath.Base(v), info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("getUploadConfig returns error: %w", timeoutError(parent, ctx, err))
+	}
+	if _, err := api.Resume(ctx, config.Token); err != nil {
+		return nil, fmt.Errorf("uploadRequest returns error: %w", timeoutError(parent, ctx, err))
+	}
+
+	partSize := int64(config.Config.PartSize - 1)
+	policy := retryPolicy(config.Config.RetryCount, time.Duration(config.Config.RetryDurationSeconds)*time.Second)
+	contentType := fragmentContentType(v)
+	hash := newHash()
+	bar := pb.Full.New(0).SetTotal(info.Size()).Set(pb.Bytes, true)
+	if noColor() {
+		bar.Set(pb.Color, false)
+	}
+	bar.Start()
+	defer bar.Finish()
+
+	stats := &Upload{Meta: meta, Parallel: 1, ParallelSource: "tail"}
+	send := func(part, offset int64, length int, total int64) error {
+		buf, err := tailRead(ctx, file, offset, length)
+		if err != nil {
+			return fmt.Errorf("failed reading part %d at offset %d: %v", part, offset, err)
+		}
+		_, _ = hash.Write(buf)
+		sum := md5.Sum(buf)
+		postURL := fmt.Sprintf("%s?upload_token=%s&fragment_id=%d", UploadEndpoint, config.Token, part)
+		attempts := 0
+		err = retry(ctx, fmt.Sprintf("part %d", part), policy, func() error {
+			if attempts++; attempts > 1 {
+				stats.Retries++
+			}
+			req, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewReader(buf))
+			if err != nil {
+				return err
+			}
+			if err := setFragmentHeaders(req, contentType, offset, length, total); err != nil {
+				return err
+			}
+			checksum, err := upload(req, part, length)
+			if err == nil && checksum != hex.EncodeToString(sum[:]) {
+				err = &FragmentError{Part: part, Err: fmt.Errorf("part %d checksum is wrong: %x, %s", part, sum, checksum)}
+			}
+			return err
+		})
+		if err == nil {
+			bar.Add(length)
+		}
+		return err
+	}
+
+	var part, offset int64
+	size, changed := info.Size(), time.Now()
+	for {
+		// whole fragments are sent while the file grows, the last one
+		// waits for the final size
+		for size-offset > partSize {
+			if err := send(part, offset, int(partSize), -1); err != nil {
+				return stats, fmt.Errorf("upload aborted, %s is not finished: %w", v, timeoutError(parent, ctx, err))
+			}
+			part++
+			offset += partSize
+		}
+		if time.Since(changed) >= *tailQuiet {
+			break
+		}
+		sleep(ctx, tailPoll)
+		if ctx.Err() != nil {
+			return stats, fmt.Errorf("upload aborted: %w", timeoutError(parent, ctx, ctx.Err()))
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return stats, fmt.Errorf("stat returns error: %w", err)
+		}
+		switch {
+		case info.Size() < size:
+			return stats, fmt.Errorf("upload aborted: %s shrank from %d to %d bytes while being uploaded", v, size, info.Size())
+		case info.Size() > size:
+			size, changed = info.Size(), time.Now()
+			bar.SetTotal(size)
+			if *debug {
+				log.Printf("%s grew to %d bytes", v, size)
+			}
+		}
+	}
+	if size > offset {
+		if err := send(part, offset, int(size-offset), size); err != nil {
+			return stats, fmt.Errorf("upload aborted, %s is not finished: %w", v, timeoutError(parent, ctx, err))
+		}
+		part++
+	}
+	if part == 0 {
+		return stats, fmt.Errorf("upload aborted: %s stayed empty for %v", v, *tailQuiet)
+	}
+	bar.Finish()
+
+	up, err := finishUpload(ctx, config.Token, part, config.TaskID, path.Base(v), meta)
+	if err != nil {
+		return stats, fmt.Errorf("finishUpload returns error: %w", timeoutError(parent, ctx, err))
+	}
+	up.Retries = stats.Retries
+	up.Parallel, up.ParallelSource = stats.Parallel, stats.ParallelSource
+	up.Hash = formatHash(hash)
+	return up, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Temp files (-audio renders, -clip segments, -auto-cover frames) are all
+// created through tempDir, so that an interrupted run removes them too.
+// ioutil.TempDir creates them readable by the current user only.
+var (
+	tempMu   sync.Mutex
+	tempDirs = make(map[string]bool)
+)
+
+// tempDir creates a private temp directory under -tmp-dir.
+func tempDir(prefix string) (string, error) {
+	dir, err := ioutil.TempDir(*tmpDir, prefix)
+	if err != nil {
+		return "", err
+	}
+	tempMu.Lock()
+	tempDirs[dir] = true
+	tempMu.Unlock()
+	return dir, nil
+}
+
+// removeTemp deletes a directory made by tempDir, unless -keep-temp is set.
+func removeTemp(dir string) {
+	if dir == "" {
+		return
+	}
+	tempMu.Lock()
+	delete(tempDirs, dir)
+	tempMu.Unlock()
+	if *keepTemp {
+		fmt.Fprintf(msg, tr("Kept temp files in %s\n"), dir)
+		return
+	}
+	_ = os.RemoveAll(dir)
+}
+
+// removeAllTemp deletes every temp directory still around.
+func removeAllTemp() {
+	tempMu.Lock()
+	dirs := make([]string, 0, len(tempDirs))
+	for dir := range tempDirs {
+		dirs = append(dirs, dir)
+	}
+	tempMu.Unlock()
+	for _, dir := range dirs {
+		removeTemp(dir)
+	}
+}
+
+// interruptContext returns a context canceled by Ctrl-C or SIGTERM, so
+// that the run stops where it is and its deferred cleanup, temp files
+// included, still happens. A second signal kills the process at once.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// signals have their default effect again after stop
+		stop()
+	}()
+	return ctx, stop
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	histogramBins  = 8
+	histogramWidth = 40
+)
+
+// fragmentRates collects the throughput of every uploaded fragment. The
+// spread tells a link that is slow all along from one that stalls now and
+// then, which the average over the whole file hides.
+type fragmentRates struct {
+	mu    sync.Mutex
+	rates []float64 // bytes per second
+}
+
+func (f *fragmentRates) Add(n int, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rates = append(f.rates, float64(n)/d.Seconds())
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Log writes the percentiles and a histogram of the rates of file.
+func (f *fragmentRates) Log(file string) {
+	f.mu.Lock()
+	rates := append([]float64(nil), f.rates...)
+	f.mu.Unlock()
+	if len(rates) == 0 {
+		return
+	}
+	sort.Float64s(rates)
+	lo, hi := rates[0], rates[len(rates)-1]
+	log.Printf("%s: %d fragment(s), throughput p50 %s, p90 %s, p99 %s, min %s, max %s", file, len(rates),
+		formatRate(percentile(rates, 50)), formatRate(percentile(rates, 90)), formatRate(percentile(rates, 99)),
+		formatRate(lo), formatRate(hi))
+
+	bins := histogramBins
+	if hi == lo {
+		bins = 1
+	}
+	counts := make([]int, bins)
+	most := 0
+	for _, r := range rates {
+		i := bins - 1
+		if hi > lo {
+			i = int((r - lo) / (hi - lo) * float64(bins))
+			if i == bins {
+				i--
+			}
+		}
+		counts[i]++
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+	step := (hi - lo) / float64(bins)
+	for i, n := range counts {
+		from, to := lo+step*float64(i), lo+step*float64(i+1)
+		log.Printf("  %12s - %-12s %5d %s", formatRate(from), formatRate(to), n,
+			strings.Repeat("#", n*histogramWidth/most))
+	}
+}
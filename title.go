@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const titleTemplateHelp = "Title template for batch uploads, e.g. \"{parent} - {name} #{index}\". " +
+	"Placeholders: {name} file name without extension, {index} position in the batch, " +
+	"{date} upload date (YYYY-MM-DD), {parent} name of the parent directory"
+
+var titlePlaceholders = map[string]bool{
+	"name":   true,
+	"index":  true,
+	"date":   true,
+	"parent": true,
+}
+
+// checkTitleTemplate makes sure every brace in tmpl belongs to a known
+// placeholder.
+func checkTitleTemplate(tmpl string) error {
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			break
+		}
+		if rest[open] == '}' {
+			return fmt.Errorf("invalid title template %q: unexpected }", tmpl)
+		}
+		end := strings.IndexAny(rest[open+1:], "{}")
+		if end < 0 || rest[open+1+end] != '}' {
+			return fmt.Errorf("invalid title template %q: unclosed {", tmpl)
+		}
+		name := rest[open+1 : open+1+end]
+		if !titlePlaceholders[name] {
+			return fmt.Errorf("invalid title template %q: unknown placeholder {%s}", tmpl, name)
+		}
+		rest = rest[open+end+2:]
+	}
+	return nil
+}
+
+// parentDir is the name of the directory file is in.
+func parentDir(file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	return filepath.Base(filepath.Dir(abs))
+}
+
+// renderTitle fills in the placeholders of a template checked by
+// checkTitleTemplate, index starts at 1.
+func renderTitle(tmpl, file string, index int) string {
+	base := filepath.Base(file)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{index}", strconv.Itoa(index),
+		"{date}", time.Now().Format("2006-01-02"),
+		"{parent}", parentDir(file),
+	).Replace(tmpl)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// traceTransport logs the latency breakdown of every request and keeps
+// per-host totals for the summary printed at the end of the run.
+type traceTransport struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	hosts map[string]*hostTrace
+}
+
+type hostTrace struct {
+	requests int
+	conns    int
+	tlsConns int
+	dns      time.Duration
+	connect  time.Duration
+	tls      time.Duration
+	ttfb     time.Duration
+}
+
+// requestTrace collects the timings of one request, the callbacks may run
+// on other goroutines.
+type requestTrace struct {
+	mu                                   sync.Mutex
+	start, dnsStart, connStart, tlsStart time.Time
+	dns, connect, tls, ttfb              time.Duration
+}
+
+func newTraceTransport(next http.RoundTripper) *traceTransport {
+	return &traceTransport{next: next, hosts: make(map[string]*hostTrace)}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := &requestTrace{start: time.Now()}
+	lock := func(f func()) {
+		rt.mu.Lock()
+		f()
+		rt.mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock(func() { rt.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock(func() { rt.dns = time.Since(rt.dnsStart) })
+		},
+		ConnectStart: func(_, _ string) {
+			lock(func() { rt.connStart = time.Now() })
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			lock(func() { rt.connect = time.Since(rt.connStart) })
+		},
+		TLSHandshakeStart: func() {
+			lock(func() { rt.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { rt.tls = time.Since(rt.tlsStart) })
+		},
+		GotFirstResponseByte: func() {
+			lock(func() { rt.ttfb = time.Since(rt.start) })
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := t.next.RoundTrip(req)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	log.Printf("trace %s %s%s: dns=%v connect=%v tls=%v ttfb=%v",
+		req.Method, req.URL.Host, req.URL.Path, rt.dns, rt.connect, rt.tls, rt.ttfb)
+	t.record(req.URL.Host, rt)
+	return resp, err
+}
+
+func (t *traceTransport) record(host string, rt *requestTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.hosts[host]
+	if h == nil {
+		h = new(hostTrace)
+		t.hosts[host] = h
+	}
+	h.requests++
+	h.ttfb += rt.ttfb
+	h.dns += rt.dns
+	if rt.connect > 0 {
+		h.conns++
+		h.connect += rt.connect
+	}
+	if rt.tls > 0 {
+		h.tlsConns++
+		h.tls += rt.tls
+	}
+}
+
+func (t *traceTransport) PrintSummary() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := make([]string, 0, len(t.hosts))
+	for name := range t.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	avg := func(d time.Duration, n int) time.Duration {
+		if n == 0 {
+			return 0
+		}
+		return (d / time.Duration(n)).Round(time.Millisecond)
+	}
+	fmt.Fprint(msg, tr("Trace summary (averages, connect/tls over new connections only):\n"))
+	for _, name := range names {
+		h := t.hosts[name]
+		fmt.Fprintf(msg, tr("  %s: %d request(s), %d connection(s), dns=%v connect=%v tls=%v ttfb=%v\n"),
+			name, h.requests, h.conns, avg(h.dns, h.conns), avg(h.connect, h.conns), avg(h.tls, h.tlsConns), avg(h.ttfb, h.requests))
+	}
+}
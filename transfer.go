@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+const (
+	probeParts     = 2
+	maxAutoWorkers = 16
+
+	// serialFallback is the number of failed fragments, before any
+	// fragment succeeded, after which uploads are serialized.
+	serialFallback = 6
+
+	// bufferSlack is how many fragments may be read ahead of the
+	// workers, on top of one per worker.
+	bufferSlack = 1
+)
+
+// Transfer holds the state shared by the producer and the workers while
+// the fragments of one file are uploaded.
+type Transfer struct {
+	ctx      context.Context
+	token    string
+	partSize int64
+	fileSize int64
+	bar      *pb.ProgressBar
+
+	// contentType is sent with every fragment, see -content-type.
+	contentType string
+
+	// cancel stops the workers once a fragment ran out of attempts under
+	// retry or the file's retry budget, err is then returned by Run.
+	cancel  context.CancelFunc
+	retry   *RetryPolicy
+	budget  int64
+	err     error
+	errOnce sync.Once
+
+	ch        chan *UploadPart
+	wg        sync.WaitGroup
+	workersMu sync.Mutex
+	workers   int
+	retries   int64
+
+	succeeded int64
+	serial    int32
+	serialMu  sync.Mutex
+
+	// confirmed has one entry per fragment of the file, the complete
+	// call is only made once every one of them is set.
+	confirmedMu sync.Mutex
+	confirmed   []bool
+
+	// hash, when set, receives the whole file in order while the
+	// fragments are read. It is dropped if fragments are skipped.
+	hash hash.Hash
+
+	// state is set when resuming is enabled, confirmed fragments are
+	// skipped and new ones recorded in it.
+	state *ResumeState
+
+	// grid shows the state of every fragment with -progress=grid, it
+	// is nil otherwise.
+	grid *fragmentGrid
+
+	// rates has the throughput of every uploaded fragment, it is logged
+	// at the end of the file in verbose mode.
+	rates fragmentRates
+
+	// buffers bounds the fragments held in memory, from being read until
+	// they are uploaded or given up, to workers+bufferSlack.
+	buffers *bufferLimit
+}
+
+// bufferLimit is a semaphore whose size follows the number of workers.
+type bufferLimit struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	held  int
+	limit int
+	peak  int
+}
+
+func newBufferLimit() *bufferLimit {
+	b := &bufferLimit{limit: 1 + bufferSlack}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits for a free buffer, it returns false once ctx is done.
+func (b *bufferLimit) acquire(ctx context.Context) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.held >= b.limit && ctx.Err() == nil {
+		b.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	b.held++
+	if b.held > b.peak {
+		b.peak = b.held
+	}
+	return true
+}
+
+func (b *bufferLimit) release() {
+	b.mu.Lock()
+	b.held--
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *bufferLimit) setWorkers(n int) {
+	b.mu.Lock()
+	b.limit = n + bufferSlack
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// Peak returns the most buffers held at once.
+func (b *bufferLimit) Peak() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
+
+func newTransfer(ctx context.Context, token string, partSize int64, fileSize int64, bar *pb.ProgressBar) *Transfer {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Transfer{
+		ctx:       ctx,
+		cancel:    cancel,
+		retry:     retryPolicy(0, retryDelay),
+		budget:    int64(*retryBudget),
+		token:     token,
+		partSize:  partSize,
+		fileSize:  fileSize,
+		bar:       bar,
+		ch:        make(chan *UploadPart),
+		confirmed: make([]bool, (fileSize+partSize-1)/partSize),
+		buffers:   newBufferLimit(),
+	}
+}
+
+func (t *Transfer) confirm(part int64) {
+	t.confirmedMu.Lock()
+	defer t.confirmedMu.Unlock()
+	t.confirmed[part] = true
+}
+
+// Missing returns the fragments neither uploaded in this run nor
+// confirmed by an earlier session.
+func (t *Transfer) Missing() []int64 {
+	t.confirmedMu.Lock()
+	defer t.confirmedMu.Unlock()
+	var missing []int64
+	for part, ok := range t.confirmed {
+		if !ok && !t.state.Confirmed(int64(part)) {
+			missing = append(missing, int64(part))
+		}
+	}
+	return missing
+}
+
+// SetWorkers starts or stops workers until n of them are running. A nil
+// part tells a worker to quit.
+func (t *Transfer) SetWorkers(n int) {
+	t.workersMu.Lock()
+	defer t.workersMu.Unlock()
+	for ; t.workers < n; t.workers++ {
+		go t.uploader()
+	}
+	for ; t.workers > n; t.workers-- {
+		t.ch <- nil
+	}
+	t.buffers.setWorkers(n)
+}
+
+// Run reads r in fragments and hands them to the workers, it returns the
+// number of fragments once all of them are uploaded. Every fragment is
+// read from its own window at part*partSize, so fragments always start on
+// a part boundary no matter which ones were skipped or finish first. A
+// read error stops the transfer and is returned, the file must not be
+// finished then since the server only has part of it.
+func (t *Transfer) Run(r io.ReaderAt, parallel int) (int64, error) {
+	// fragments counts the fragments handed out or skipped as confirmed,
+	// it is also the index of the next one
+	var fragments int64
+	var readErr error
+	next := func() *UploadPart {
+		if readErr != nil {
+			return nil
+		}
+		for t.state.Confirmed(fragments) {
+			t.hash = nil
+			t.grid.Set(fragments, fragDone)
+			fragments++
+		}
+		offset := fragments * t.partSize
+		if offset >= t.fileSize {
+			return nil
+		}
+		size := t.fileSize - offset
+		if size > t.partSize {
+			size = t.partSize
+		}
+		if !t.buffers.acquire(t.ctx) {
+			return nil
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(io.NewSectionReader(r, offset, size), buf); err != nil {
+			t.buffers.release()
+			readErr = fmt.Errorf("failed reading part %d at offset %d: %v", fragments, offset, err)
+			return nil
+		}
+		if t.hash != nil {
+			_, _ = t.hash.Write(buf)
+		}
+		item := &UploadPart{
+			content: buf,
+			count:   fragments,
+			offset:  offset,
+		}
+		fragments++
+		return item
+	}
+
+	// wake up a producer waiting for a buffer when the transfer stops
+	go func() {
+		<-t.ctx.Done()
+		t.buffers.setWorkers(t.Workers())
+	}()
+
+	eof := false
+	if *autoParallel {
+		parallel, eof = t.probe(next)
+	}
+	t.SetWorkers(parallel)
+	keysDone := t.watchKeys()
+	for !eof && t.ctx.Err() == nil {
+		item := next()
+		if item == nil {
+			break
+		}
+		t.send(item)
+	}
+
+	t.wg.Wait()
+	keysDone()
+	close(t.ch)
+	stopped := t.ctx.Err() != nil
+	t.cancel()
+	if *debug {
+		log.Printf("at most %d fragment(s) were buffered at once", t.buffers.Peak())
+	}
+	if t.err != nil {
+		return fragments, t.err
+	}
+	if readErr == nil && !stopped && fragments != int64(len(t.confirmed)) {
+		return fragments, fmt.Errorf("read %d fragments, a %d byte file has %d", fragments, t.fileSize, len(t.confirmed))
+	}
+	return fragments, readErr
+}
+
+// abort stops the transfer, Run returns err.
+func (t *Transfer) abort(err error) {
+	t.errOnce.Do(func() {
+		t.err = err
+		t.cancel()
+	})
+}
+
+// watchKeys adjusts the number of workers on -keys presses until the
+// returned function is called.
+func (t *Transfer) watchKeys() func() {
+	if workerKeys == nil {
+		return func() {}
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case d := <-workerKeys:
+				t.workersMu.Lock()
+				n := t.workers + d
+				t.workersMu.Unlock()
+				if n < 1 || n > maxAutoWorkers {
+					log.Printf("workers stay at %d (between 1 and %d)", n-d, maxAutoWorkers)
+					continue
+				}
+				t.SetWorkers(n)
+				log.Printf("workers: %d", n)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// Workers returns the number of running workers.
+func (t *Transfer) Workers() int {
+	t.workersMu.Lock()
+	defer t.workersMu.Unlock()
+	return t.workers
+}
+
+// Serial reports whether the transfer fell back to serial uploads.
+func (t *Transfer) Serial() bool {
+	return atomic.LoadInt32(&t.serial) == 1
+}
+
+// Retries returns the number of failed fragment attempts so far.
+func (t *Transfer) Retries() int64 {
+	return atomic.LoadInt64(&t.retries)
+}
+
+// send hands a part to a worker unless the transfer is cancelled first.
+func (t *Transfer) send(item *UploadPart) bool {
+	t.wg.Add(1)
+	select {
+	case t.ch <- item:
+		return true
+	case <-t.ctx.Done():
+		t.buffers.release()
+		t.wg.Done()
+		return false
+	}
+}
+
+// probe uploads the first fragments with 1, 2, 4... workers and stops
+// doubling once the throughput no longer improves or retries show up. It
+// returns the best worker count, and whether the file ran out meanwhile.
+func (t *Transfer) probe(next func() *UploadPart) (int, bool) {
+	best, bestRate := 1, 0.0
+	for n := 1; n <= maxAutoWorkers && t.ctx.Err() == nil; n *= 2 {
+		t.SetWorkers(n)
+		retries := atomic.LoadInt64(&t.retries)
+		start := time.Now()
+		sent, eof := 0, false
+		for i := 0; i < n*probeParts; i++ {
+			item := next()
+			if item == nil {
+				eof = true
+				break
+			}
+			if !t.send(item) {
+				break
+			}
+			sent += len(item.content)
+		}
+		t.wg.Wait()
+		rate := float64(sent) / time.Since(start).Seconds()
+		if *debug {
+			log.Printf("concurrency probe: %d worker(s), %.0f B/s", n, rate)
+		}
+		if eof {
+			return n, true
+		}
+		if atomic.LoadInt64(&t.retries) > retries || rate < bestRate*1.1 {
+			break
+		}
+		best, bestRate = n, rate
+	}
+	log.Printf("concurrency probe settled on %d worker(s)", best)
+	return best, false
+}
+
+func (t *Transfer) uploader() {
+	for item := range t.ch {
+		if item == nil {
+			return
+		}
+		if *debug {
+			log.Printf("part %d start uploading", item.count)
+		}
+		t.grid.Set(item.count, fragUploading)
+		var md5Hash string
+		var md5Wg sync.WaitGroup
+		md5Wg.Add(1)
+		go func() {
+			defer md5Wg.Done()
+			sum := md5.Sum(item.content)
+			md5Hash = hex.EncodeToString(sum[:])
+		}()
+		postURL := fmt.Sprintf("%s?upload_token=%s&fragment_id=%d", UploadEndpoint, t.token, item.count)
+		for failures := 0; t.ctx.Err() == nil; {
+			began := time.Now()
+			checksum, err := t.attempt(item, postURL)
+			md5Wg.Wait()
+			if err == nil && md5Hash != checksum {
+				err = &FragmentError{Part: item.count, Err: fmt.Errorf("part %d checksum is wrong: %s, %s", item.count, md5Hash, checksum)}
+			}
+			if err != nil {
+				if t.ctx.Err() != nil {
+					break
+				}
+				log.Printf("%v", err)
+				t.grid.Set(item.count, fragRetrying)
+				if t.failed() {
+					t.abort(fmt.Errorf("retry budget of %d exhausted: %w", t.budget, err))
+					break
+				}
+				if failures++; t.retry.Exhausted(failures) {
+					t.abort(fmt.Errorf("giving up after %d attempts: %w", failures, err))
+					break
+				}
+				sleep(t.ctx, t.retry.Wait(failures))
+				t.grid.Set(item.count, fragUploading)
+				continue
+			}
+			atomic.AddInt64(&t.succeeded, 1)
+			t.rates.Add(len(item.content), time.Since(began))
+			t.grid.Set(item.count, fragDone)
+			t.confirm(item.count)
+			t.state.Confirm(item.count)
+			t.bar.Add(len(item.content))
+			break
+		}
+		t.buffers.release()
+		t.wg.Done()
+	}
+}
+
+// attempt makes one try at uploading item. The request is cancelled when
+// its body stops moving for -stall-timeout.
+func (t *Transfer) attempt(item *UploadPart, postURL string) (string, error) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+	body := newStallReader(item.content)
+	req, err := http.NewRequestWithContext(ctx, "POST", postURL, body)
+	if err != nil {
+		return "", &FragmentError{Part: item.count, Err: err}
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(item.content)), nil
+	}
+	if err := setFragmentHeaders(req, t.contentType, item.offset, len(item.content), t.fileSize); err != nil {
+		// a bug rather than a network problem, retrying can't help
+		err = &FragmentError{Part: item.count, Err: err}
+		t.abort(err)
+		return "", err
+	}
+	if *debug {
+		log.Println(req.Header)
+	}
+	stalled := watchStall(body, *stallTimeout, cancel)
+	checksum, err := t.upload(req, item.count, len(item.content))
+	if stalled() {
+		return "", &FragmentError{Part: item.count,
+			Err: fmt.Errorf("part %d stalled, no data sent for %v (retring)", item.count, *stallTimeout)}
+	}
+	return checksum, err
+}
+
+// upload sends one fragment, one at a time once the transfer fell back
+// to serial mode.
+func (t *Transfer) upload(req *http.Request, count int64, length int) (string, error) {
+	if atomic.LoadInt32(&t.serial) == 1 {
+		t.serialMu.Lock()
+		defer t.serialMu.Unlock()
+	}
+	return upload(req, count, length)
+}
+
+// failed counts a failed fragment and switches to serial mode when
+// parallel fragments keep failing before any of them went through, as
+// some accounts reject concurrent fragment uploads. It reports whether
+// the file is now over its retry budget.
+func (t *Transfer) failed() (overBudget bool) {
+	retries := atomic.AddInt64(&t.retries, 1)
+	if retries >= serialFallback && atomic.LoadInt64(&t.succeeded) == 0 &&
+		atomic.CompareAndSwapInt32(&t.serial, 0, 1) {
+		log.Printf("warning: %d fragments failed while uploading in parallel, falling back to serial upload "+
+			"(use -parallel-safe to start serial)", retries)
+	}
+	return t.budget > 0 && retries > t.budget
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// testTransfer returns a transfer of size bytes in fragments of partSize
+// that retries right away.
+func testTransfer(size, partSize int64) *Transfer {
+	t := newTransfer(context.Background(), "tok", partSize, size, pb.New64(size))
+	t.contentType = "application/octet-stream"
+	t.retry = &RetryPolicy{Delay: time.Millisecond}
+	return t
+}
+
+func testContent(size int64) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestTransferOutOfOrder(t *testing.T) {
+	const parts = 4
+	answered := make([]chan struct{}, parts)
+	for i := range answered {
+		answered[i] = make(chan struct{})
+	}
+	// every fragment is answered only after the one behind it
+	f := &fakeAcFun{
+		hold: func(part int64) {
+			if part+1 < parts {
+				select {
+				case <-answered[part+1]:
+				case <-time.After(5 * time.Second):
+				}
+			}
+		},
+		done: func(part int64) { close(answered[part]) },
+	}
+	testServer(t, f)
+
+	size := int64(3500)
+	tr := testTransfer(size, 1000)
+	n, err := tr.Run(bytes.NewReader(testContent(size)), parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != parts {
+		t.Fatalf("got %d fragments, want %d", n, parts)
+	}
+	if got, want := f.Fragments(), []int64{3, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("fragments answered in order %v, want %v", got, want)
+	}
+	if missing := tr.Missing(); len(missing) > 0 {
+		t.Fatalf("fragments %v not confirmed", missing)
+	}
+	for part, ok := range tr.confirmed {
+		if !ok {
+			t.Errorf("fragment %d not confirmed", part)
+		}
+	}
+
+	if err := api.Complete(context.Background(), "tok", n); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Completes(); !reflect.DeepEqual(got, []string{"4"}) {
+		t.Errorf("complete called with fragment_count %v, want [4]", got)
+	}
+}
+
+func TestTransferGap(t *testing.T) {
+	f := &fakeAcFun{fail: func(part int64, attempt int) bool { return part == 2 }}
+	testServer(t, f)
+
+	size := int64(4000)
+	tr := testTransfer(size, 1000)
+	tr.retry.Attempts = 2
+	if _, err := tr.Run(bytes.NewReader(testContent(size)), 1); err == nil {
+		t.Fatal("a transfer with a failed fragment returned no error")
+	}
+	missing := tr.Missing()
+	if len(missing) == 0 || missing[0] != 2 {
+		t.Errorf("missing fragments %v, want 2 first", missing)
+	}
+}
+
+// fakeFileInfo is the FileInfo of a file that doesn't have to exist.
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f *fakeFileInfo) Name() string       { return f.name }
+func (f *fakeFileInfo) Size() int64        { return f.size }
+func (f *fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (f *fakeFileInfo) ModTime() time.Time { return f.modTime }
+func (f *fakeFileInfo) IsDir() bool        { return false }
+func (f *fakeFileInfo) Sys() interface{}   { return nil }
+
+// zeros reads as size zero bytes.
+type zeros struct {
+	size int64
+}
+
+func (z zeros) ReadAt(p []byte, off int64) (int, error) {
+	if off >= z.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if rest := z.size - off; rest < int64(n) {
+		n = int(rest)
+	}
+	for i := range p[:n] {
+		p[i] = 0
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// A file over 4GiB whose fragments but the last were uploaded before:
+// the offsets, counts and Content-Range of the last one must not wrap
+// around in 32 bits.
+func TestTransferLargeFile(t *testing.T) {
+	useStateDir(t)
+	f := &fakeAcFun{}
+	testServer(t, f)
+
+	const partSize = 1 << 20
+	info := &fakeFileInfo{name: "large.mp4", size: 5<<30 + 1000, modTime: time.Unix(1600000000, 0)}
+	const fragments = 5<<10 + 1
+	saved := &ResumeState{
+		Path: "large.mp4", Size: info.size, ModTime: info.modTime,
+		Token: "tok", TaskID: "task", PartSize: partSize + 1, Parallel: 1,
+		Fragments: fragments, Created: time.Now(), done: make(map[int64]bool),
+	}
+	for part := int64(0); part < fragments-1; part++ {
+		saved.done[part] = true
+	}
+	file, err := resumeStateFile("large.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.file = file
+	if err := saved.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	state := loadResumeState("large.mp4", info)
+	if state == nil {
+		t.Fatal("the saved state was not loaded")
+	}
+	if got, want := state.ConfirmedBytes(partSize), int64(5<<30); got != want {
+		t.Fatalf("confirmed %d bytes, want %d", got, want)
+	}
+	tr := testTransfer(info.Size(), partSize)
+	tr.state = state
+	if got := int64(len(tr.confirmed)); got != fragments {
+		t.Fatalf("transfer has %d fragments, want %d", got, fragments)
+	}
+	n, err := tr.Run(zeros{info.Size()}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != fragments {
+		t.Errorf("got %d fragments, want %d", n, fragments)
+	}
+	if got := f.Fragments(); !reflect.DeepEqual(got, []int64{fragments - 1}) {
+		t.Fatalf("uploaded fragments %v, want only %d", got, fragments-1)
+	}
+	if got, want := f.ranges[fragments-1], "bytes 5368709120-5368710119/5368710120"; got != want {
+		t.Errorf("Content-Range %q, want %q", got, want)
+	}
+	if missing := tr.Missing(); len(missing) > 0 {
+		t.Errorf("fragments %v not confirmed", missing)
+	}
+}
+
+// The first attempt at the fragment hangs like a slow-loris server: the
+// connection stays open but its body is never read. The stall watch must
+// abort it and the retry go through.
+func TestTransferStall(t *testing.T) {
+	setFlag(t, "stall-timeout", "200ms")
+	f := &fakeAcFun{}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	attempts := 0
+	testServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			<-release
+			return
+		}
+		f.ServeHTTP(w, r)
+	}))
+	// before the server is closed, which waits for the hanging handler
+	t.Cleanup(func() { close(release) })
+
+	// far more than the socket buffers take in without being read
+	size := int64(32 << 20)
+	tr := testTransfer(size, size)
+	start := time.Now()
+	n, err := tr.Run(bytes.NewReader(testContent(size)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d fragments, want 1", n)
+	}
+	if tr.Retries() != 1 {
+		t.Errorf("got %d retries, want 1", tr.Retries())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("the fragment was sent %d times, want 2", attempts)
+	}
+	// the client timeout would have ended the attempt after 10s
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the stalled attempt was only given up after %v", d)
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	b := newBufferLimit()
+	b.setWorkers(3)
+	var mu sync.Mutex
+	held, most := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !b.acquire(context.Background()) {
+				t.Error("acquire failed without a cancel")
+				return
+			}
+			mu.Lock()
+			if held++; held > most {
+				most = held
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			held--
+			mu.Unlock()
+			b.release()
+		}()
+	}
+	wg.Wait()
+	if limit := 3 + bufferSlack; most > limit || b.Peak() > limit {
+		t.Errorf("%d buffers held at once (peak %d), the limit is %d", most, b.Peak(), limit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 3+bufferSlack; i++ {
+		b.acquire(ctx)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		// acquire waits on the cond, a cancel alone doesn't wake it
+		b.setWorkers(3)
+	}()
+	if b.acquire(ctx) {
+		t.Error("acquire over the limit succeeded")
+	}
+}
+
+// Every fragment fails a few times before it goes through: the retried
+// fragments must not pile up in memory.
+func TestTransferBufferLimitRetries(t *testing.T) {
+	f := &fakeAcFun{
+		fail: func(part int64, attempt int) bool { return attempt <= 3 },
+		hold: func(part int64) { time.Sleep(2 * time.Millisecond) },
+	}
+	testServer(t, f)
+
+	size := int64(20 * 1000)
+	tr := testTransfer(size, 1000)
+	const parallel = 3
+	if _, err := tr.Run(bytes.NewReader(testContent(size)), parallel); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Retries() != 60 {
+		t.Errorf("got %d retries, want 60", tr.Retries())
+	}
+	if peak, limit := tr.buffers.Peak(), parallel+bufferSlack; peak > limit {
+		t.Errorf("%d fragments buffered at once, the limit is %d", peak, limit)
+	}
+}
+
+// failingReader reads like r until failAt, where the disk gives out.
+type failingReader struct {
+	r      io.ReaderAt
+	failAt int64
+}
+
+var errDisk = errors.New("input/output error")
+
+func (f *failingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > f.failAt {
+		return 0, errDisk
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestTransferReadError(t *testing.T) {
+	f := &fakeAcFun{}
+	testServer(t, f)
+
+	size := int64(5000)
+	tr := testTransfer(size, 1000)
+	r := &failingReader{r: bytes.NewReader(testContent(size)), failAt: 2500}
+	_, err := tr.Run(r, 1)
+	if err == nil || !strings.Contains(err.Error(), "failed reading part 2") || !strings.Contains(err.Error(), errDisk.Error()) {
+		t.Fatalf("got %v, want the read error of part 2", err)
+	}
+	if got := f.Fragments(); !reflect.DeepEqual(got, []int64{0, 1}) {
+		t.Errorf("uploaded fragments %v, want only the ones read before the error", got)
+	}
+	if got, want := tr.Missing(), []int64{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing fragments %v, want %v", got, want)
+	}
+}
+
+// Every fragment must start on a multiple of the part size and be a whole
+// part long except the last, retries included.
+func TestTransferAlignment(t *testing.T) {
+	const partSize = 1000
+	for _, size := range []int64{1, 999, 1000, 1001, 2500, 3000, 3001} {
+		for _, retries := range []bool{false, true} {
+			f := &fakeAcFun{}
+			if retries {
+				f.fail = func(part int64, attempt int) bool { return attempt == 1 }
+			}
+			testServer(t, f)
+			tr := testTransfer(size, partSize)
+			n, err := tr.Run(bytes.NewReader(testContent(size)), 3)
+			if err != nil {
+				t.Fatalf("%d bytes, retries %v: %v", size, retries, err)
+			}
+			for part := int64(0); part < n; part++ {
+				start := part * partSize
+				end := start + partSize - 1
+				if part == n-1 {
+					end = size - 1
+				}
+				want := fmt.Sprintf("bytes %d-%d/%d", start, end, size)
+				if got := f.ranges[part]; got != want {
+					t.Errorf("%d bytes, retries %v: fragment %d has Content-Range %q, want %q", size, retries, part, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestTransferFragmentCount(t *testing.T) {
+	const p = 1000
+	tests := []struct {
+		size      int64
+		fragments int64
+	}{
+		{0, 0},
+		{1, 1},
+		{p - 1, 1},
+		{p, 1},
+		{p + 1, 2},
+		{3 * p, 3},
+		{3*p + 1, 4},
+	}
+	for _, tt := range tests {
+		f := &fakeAcFun{}
+		testServer(t, f)
+		tr := testTransfer(tt.size, p)
+		if got := int64(len(tr.confirmed)); got != tt.fragments {
+			t.Errorf("%d bytes: transfer has %d fragments, want %d", tt.size, got, tt.fragments)
+		}
+		n, err := tr.Run(bytes.NewReader(testContent(tt.size)), 2)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tt.size, err)
+		}
+		if n != tt.fragments {
+			t.Errorf("%d bytes: got %d fragments, want %d", tt.size, n, tt.fragments)
+		}
+		if got := int64(len(f.Fragments())); got != tt.fragments {
+			t.Errorf("%d bytes: server got %d fragments, want %d", tt.size, got, tt.fragments)
+		}
+	}
+}
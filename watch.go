@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const watchInterval = 5 * time.Second
+
+var (
+	watch       = flag.String("watch", "", "Keep running and upload every new file that appears in this directory, until interrupted or -timeout")
+	watchSettle = flag.Duration("watch-settle", 30*time.Second, "With -watch, wait until a new file stopped changing for this long before uploading it")
+)
+
+// checkWatch rejects flags that don't make sense for a directory whose
+// files are not known yet.
+func checkWatch() error {
+	if *watch == "" {
+		return nil
+	}
+	switch {
+	case *retryFailed:
+		return errors.New(tr("-watch and -retry-failed can't be used together"))
+	case *audio != "":
+		return errors.New(tr("-watch and -audio can't be used together"))
+	case *uploadToken != "":
+		return errors.New(tr("-watch and -upload-token can't be used together"))
+	case *estimateOnly:
+		return errors.New(tr("-watch and -estimate can't be used together"))
+	case *watchSettle <= 0:
+		return errors.New(tr("-watch-settle must be positive"))
+	}
+	return nil
+}
+
+// watchedFile is a new file that is still being written, or was when it
+// was last looked at.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// watchDir polls dir and calls upload for every new file once its size and
+// modification time stayed the same for settle, so that recordings are
+// only uploaded after the recorder is done with them. Files that are in
+// dir when the watch starts are left alone, as are hidden ones. Each file
+// is handed to upload once, -manifest-output also catches files that were
+// moved away and back. watchDir returns when ctx is done or upload returns
+// false.
+func watchDir(ctx context.Context, dir string, settle time.Duration, upload func(string) bool) error {
+	seen := make(map[string]bool)
+	files, err := listWatched(dir)
+	if err != nil {
+		return err
+	}
+	for p := range files {
+		seen[p] = true
+	}
+	interval := watchInterval
+	if settle < interval {
+		interval = settle
+	}
+	fmt.Fprintf(msg, tr("Watching %s for new files, %d already there are skipped\n"), dir, len(seen))
+
+	pending := make(map[string]*watchedFile)
+	for {
+		sleep(ctx, interval)
+		if ctx.Err() != nil {
+			return nil
+		}
+		files, err := listWatched(dir)
+		if err != nil {
+			// the directory may be remounted or briefly gone, keep going
+			log.Printf("watch: %v", err)
+			continue
+		}
+		for p := range pending {
+			if _, ok := files[p]; !ok {
+				delete(pending, p)
+			}
+		}
+		var ready []string
+		now := time.Now()
+		for p, info := range files {
+			if seen[p] {
+				continue
+			}
+			w := pending[p]
+			if w == nil || w.size != info.size || !w.modTime.Equal(info.modTime) {
+				if *debug && w == nil {
+					log.Printf("watch: found %s", p)
+				}
+				info.since = now
+				pending[p] = info
+				continue
+			}
+			if now.Sub(w.since) >= settle {
+				ready = append(ready, p)
+			}
+		}
+		sort.Strings(ready)
+		for _, p := range ready {
+			delete(pending, p)
+			seen[p] = true
+			if !upload(p) || ctx.Err() != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// listWatched returns the regular, not hidden files directly in dir.
+func listWatched(dir string) (map[string]*watchedFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*watchedFile)
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		files[filepath.Join(dir, info.Name())] = &watchedFile{size: info.Size(), modTime: info.ModTime()}
+	}
+	return files, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+const (
+	// webhookStep is the progress, in percent, between two progress events.
+	webhookStep = 10
+	// webhookInterval is the least time between two progress events.
+	webhookInterval = 5 * time.Second
+	webhookQueue    = 64
+)
+
+// WebhookEvent is the JSON body POSTed to -webhook.
+type WebhookEvent struct {
+	Event   string    `json:"event"` // started, progress, completed or failed
+	File    string    `json:"file"`
+	Percent float64   `json:"percent"`
+	VideoID int64     `json:"video_id,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Webhook delivers events in order from a single goroutine, so a slow
+// endpoint never holds up the upload. Events are dropped when the queue
+// is full and delivery errors are only logged.
+type Webhook struct {
+	url    string
+	client http.Client
+	queue  chan *WebhookEvent
+	done   chan struct{}
+}
+
+func newWebhook(url string) *Webhook {
+	w := &Webhook{
+		url:    url,
+		client: http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *WebhookEvent, webhookQueue),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		body, _ := json.Marshal(e)
+		resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("webhook returns error: %v", err)
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("webhook returns status %s", resp.Status)
+		}
+	}
+}
+
+// Send queues e, a nil Webhook ignores it.
+func (w *Webhook) Send(e *WebhookEvent) {
+	if w == nil {
+		return
+	}
+	e.Time = time.Now()
+	select {
+	case w.queue <- e:
+	default:
+		log.Printf("webhook is behind, dropping %s event of %s", e.Event, e.File)
+	}
+}
+
+// Close delivers the queued events, giving up after timeout.
+func (w *Webhook) Close(timeout time.Duration) {
+	if w == nil {
+		return
+	}
+	close(w.queue)
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+		log.Printf("webhook: gave up delivering the remaining events")
+	}
+}
+
+// Progress sends a progress event every webhookStep percent of bar, but
+// not more often than webhookInterval, until the returned function is
+// called.
+func (w *Webhook) Progress(file string, bar *pb.ProgressBar) func() {
+	if w == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		next, last := float64(webhookStep), time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			if bar.Total() <= 0 {
+				continue
+			}
+			percent := float64(bar.Current()) / float64(bar.Total()) * 100
+			if percent < next || percent >= 100 || time.Since(last) < webhookInterval {
+				continue
+			}
+			w.Send(&WebhookEvent{Event: "progress", File: file, Percent: percent})
+			for next <= percent {
+				next += webhookStep
+			}
+			last = time.Now()
+		}
+	}()
+	return func() { close(stop) }
+}